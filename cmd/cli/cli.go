@@ -1,26 +1,35 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"mime/multipart"
-	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/xconnect/xconnect-go/internal/clipboard"
 	"github.com/xconnect/xconnect-go/internal/discovery"
+	"github.com/xconnect/xconnect-go/internal/outbox"
+	"github.com/xconnect/xconnect-go/pkg/client"
 )
 
 var (
-	port     = flag.String("port", "8315", "peer service port")
-	apiToken = flag.String("api-token", "", "Tailscale API token for device list (or TAILSCALE_API_TOKEN)")
+	port          = flag.String("port", "8315", "peer service port")
+	apiToken      = flag.String("api-token", "", "Tailscale API token for device list (or TAILSCALE_API_TOKEN)")
+	discMode      = flag.String("discovery", "tailscale", "comma-separated discovery providers for list: tailscale, api, headscale, file, mdns")
+	apiBase       = flag.String("api-base", discovery.DefaultAPIBase, "Tailscale-compatible API base URL for -discovery api")
+	tailnet       = flag.String("tailnet", "-", "tailnet name for -discovery api")
+	oauthID       = flag.String("oauth-client-id", "", "Tailscale OAuth client ID (or TAILSCALE_OAUTH_CLIENT_ID); used instead of -api-token")
+	oauthSecret   = flag.String("oauth-client-secret", "", "Tailscale OAuth client secret (or TAILSCALE_OAUTH_CLIENT_SECRET)")
+	oauthTokenURL = flag.String("oauth-token-url", "", "OAuth token endpoint (default: <api-base>/oauth/token)")
+	headscaleURL  = flag.String("headscale-url", "", "Headscale server URL for -discovery headscale")
+	headscaleKey  = flag.String("headscale-key", "", "Headscale API key (or HEADSCALE_API_KEY)")
+	peersFile     = flag.String("peers-file", "", "hosts file for -discovery file")
+	outboxDir     = flag.String("outbox", outbox.DefaultDir(), "queue messages and files for unreachable peers here (delivered by xconnect -sync); empty = fail instead")
 )
 
 func main() {
@@ -46,6 +55,14 @@ func main() {
 		runMessage(rest)
 	case "file":
 		runFile(rest)
+	case "pending":
+		runPending()
+	case "accept", "reject":
+		runDecide(cmd, rest)
+	case "status":
+		runStatus(rest)
+	case "doctor":
+		runDoctor(rest)
 	default:
 		printUsage()
 		os.Exit(1)
@@ -54,30 +71,140 @@ func main() {
 
 func printUsage() {
 	fmt.Fprintf(os.Stderr, `Usage:
-  xconnect list                    list tailnet devices
+  xconnect list [selector]         list tailnet devices (optionally filtered, e.g. tag:xconnect)
   xconnect push <peer>             push local clipboard to peer
   xconnect pull <peer>             pull peer clipboard to local
   xconnect message <peer> <text>   send message (text) to peer
   xconnect file <peer> <path>      send file to peer
+  xconnect pending                 list incoming clipboard updates awaiting approval (-accept ask)
+  xconnect accept [id]             write a pending update to the clipboard (default: newest)
+  xconnect reject [id]             discard a pending update (default: newest)
+  xconnect status [peer]           show health, peers and sync stats of the local server (or peer)
+  xconnect doctor [peer]           check clipboard, Tailscale, discovery, port and peers; print fixes
 
 Peers: hostname (MagicDNS) or 100.x.x.x. Port defaults to %s.
+Messages and files for unreachable peers are queued in the outbox and delivered
+by a running "xconnect -sync" when the peer is back online.
 `, *port)
 }
 
-func baseURL(peer string) string {
-	if *port == "" {
-		*port = "8315"
+// queue stores an undeliverable message or file in the outbox, or exits with err
+// if the outbox is disabled.
+func queue(cmd, peer string, err error, add func(*outbox.Outbox) error) {
+	if *outboxDir == "" {
+		log.Fatalf("%s: %v", cmd, err)
 	}
-	return "http://" + peer + ":" + *port
+	ob, oerr := outbox.Open(*outboxDir, 0)
+	if oerr == nil {
+		oerr = add(ob)
+	}
+	if oerr != nil {
+		log.Fatalf("%s: %v (outbox: %v)", cmd, err, oerr)
+	}
+	fmt.Printf("%s is unreachable (%v); queued in %s\n", peer, err, *outboxDir)
+}
+
+// localBase returns the local xconnect server: $XCONNECT_API or 127.0.0.1:<port>.
+func localBase() string {
+	if s := os.Getenv("XCONNECT_API"); s != "" {
+		return s
+	}
+	return "http://127.0.0.1:" + *port
+}
+
+// peerClient returns an API client for peer.
+func peerClient(peer string) *client.Client {
+	return client.ForPeer(peer, *port)
+}
+
+// timeout returns the context for one API call.
+func timeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 30*time.Second)
+}
+
+func runPending() {
+	ctx, cancel := timeout()
+	defer cancel()
+	items, err := client.New(localBase()).Pending(ctx)
+	if err != nil {
+		log.Fatalf("pending: %v", err)
+	}
+	for _, it := range items {
+		preview := []rune(strings.Join(strings.Fields(it.Content), " "))
+		if len(preview) > 60 {
+			preview = append(preview[:60], '…')
+		}
+		fmt.Printf("%s\t%s\t%s\texpires in %s\t%s\n", it.ID, it.From, it.At.Local().Format("15:04:05"),
+			time.Until(it.Expires).Round(time.Second), string(preview))
+	}
+}
+
+func runDecide(cmd string, rest []string) {
+	id := ""
+	if len(rest) > 0 {
+		id = rest[0]
+	}
+	ctx, cancel := timeout()
+	defer cancel()
+	c := client.New(localBase())
+	decide := c.Reject
+	if cmd == "accept" {
+		decide = c.Accept
+	}
+	it, err := decide(ctx, id)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	if cmd == "accept" {
+		fmt.Printf("clipboard from %s accepted (%d bytes)\n", it.From, len(it.Content))
+	} else {
+		fmt.Printf("clipboard from %s rejected\n", it.From)
+	}
+}
+
+// provider returns the discovery provider configured by the flags.
+func provider() (discovery.Provider, error) {
+	if *oauthID == "" {
+		*oauthID = os.Getenv("TAILSCALE_OAUTH_CLIENT_ID")
+	}
+	if *oauthSecret == "" {
+		*oauthSecret = os.Getenv("TAILSCALE_OAUTH_CLIENT_SECRET")
+	}
+	if *headscaleKey == "" {
+		*headscaleKey = os.Getenv("HEADSCALE_API_KEY")
+	}
+	return discovery.NewProvider(discovery.Config{
+		Providers:         *discMode,
+		APIToken:          *apiToken,
+		APIBase:           *apiBase,
+		Tailnet:           *tailnet,
+		OAuthClientID:     *oauthID,
+		OAuthClientSecret: *oauthSecret,
+		OAuthTokenURL:     *oauthTokenURL,
+		HeadscaleURL:      *headscaleURL,
+		HeadscaleKey:      *headscaleKey,
+		HostsFile:         *peersFile,
+	})
 }
 
 func runList(rest []string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	devices, err := discovery.Devices(ctx, *apiToken)
+	p, err := provider()
+	if err != nil {
+		log.Fatalf("list: %v", err)
+	}
+	devices, err := discovery.AllDevices(ctx, p)
 	if err != nil {
 		log.Fatalf("list: %v", err)
 	}
+	if len(rest) > 0 {
+		sel, err := discovery.ParseSelector(rest[0])
+		if err != nil {
+			log.Fatalf("list: %v", err)
+		}
+		devices = sel.Filter(devices)
+	}
 	for _, d := range devices {
 		url := discovery.BaseURL(d, *port)
 		if url == "" {
@@ -96,16 +223,11 @@ func runPush(rest []string) {
 	if err != nil {
 		log.Fatalf("read clipboard: %v", err)
 	}
-	url := baseURL(peer) + "/clipboard"
-	resp, err := http.Post(url, "text/plain; charset=utf-8", bytes.NewReader([]byte(text)))
-	if err != nil {
+	ctx, cancel := timeout()
+	defer cancel()
+	if err := peerClient(peer).Push(ctx, text); err != nil {
 		log.Fatalf("push: %v", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("push: %s %s", resp.Status, string(body))
-	}
 	fmt.Println("clipboard pushed to", peer)
 }
 
@@ -114,21 +236,13 @@ func runPull(rest []string) {
 		log.Fatal("usage: xconnect pull <peer>")
 	}
 	peer := rest[0]
-	url := baseURL(peer) + "/clipboard"
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Fatalf("pull: %v", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("pull: %s %s", resp.Status, string(body))
-	}
-	body, err := io.ReadAll(resp.Body)
+	ctx, cancel := timeout()
+	defer cancel()
+	text, err := peerClient(peer).Pull(ctx)
 	if err != nil {
 		log.Fatalf("pull: %v", err)
 	}
-	if err := clipboard.WriteAll(string(body)); err != nil {
+	if err := clipboard.WriteAll(text); err != nil {
 		log.Fatalf("write clipboard: %v", err)
 	}
 	fmt.Println("clipboard pulled from", peer)
@@ -140,16 +254,14 @@ func runMessage(rest []string) {
 	}
 	peer := rest[0]
 	text := rest[1]
-	url := baseURL(peer) + "/message"
-	payload, _ := json.Marshal(map[string]string{"text": text})
-	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
-	if err != nil {
-		log.Fatalf("message: %v", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("message: %s %s", resp.Status, string(body))
+	ctx, cancel := timeout()
+	defer cancel()
+	if err := peerClient(peer).Message(ctx, text); err != nil {
+		if !client.IsUnreachable(err) {
+			log.Fatalf("message: %v", err)
+		}
+		queue("message", peer, err, func(ob *outbox.Outbox) error { return ob.AddMessage(peer, text) })
+		return
 	}
 	fmt.Println("message sent to", peer)
 }
@@ -165,36 +277,110 @@ func runFile(rest []string) {
 		log.Fatalf("open file: %v", err)
 	}
 	defer f.Close()
-	url := baseURL(peer) + "/files"
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-	part, err := mw.CreateFormFile("file", filepath.Base(path))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	id, err := peerClient(peer).Upload(ctx, filepath.Base(path), f)
 	if err != nil {
-		log.Fatalf("form: %v", err)
+		if !client.IsUnreachable(err) {
+			log.Fatalf("file: %v", err)
+		}
+		queue("file", peer, err, func(ob *outbox.Outbox) error { return ob.AddFile(peer, path) })
+		return
 	}
-	if _, err := io.Copy(part, f); err != nil {
-		log.Fatalf("copy: %v", err)
+	fmt.Printf("file uploaded to %s, id=%s\n", peer, id)
+}
+
+func runStatus(rest []string) {
+	c := client.New(localBase())
+	if len(rest) > 0 {
+		c = peerClient(rest[0])
 	}
-	mw.Close()
-	req, err := http.NewRequest("POST", url, &buf)
+	ctx, cancel := timeout()
+	defer cancel()
+	st, err := c.Status(ctx)
 	if err != nil {
-		log.Fatalf("request: %v", err)
+		log.Fatalf("status: %v", err)
 	}
-	req.Header.Set("Content-Type", mw.FormDataContentType())
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("file: %v", err)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	name := st.Hostname
+	if name == "" {
+		name = c.BaseURL
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("file: %s %s", resp.Status, string(body))
+	fmt.Fprintf(w, "node:\t%s, xconnect %s, up %s\n", name, st.Version, time.Duration(st.UptimeSeconds)*time.Second)
+	fmt.Fprintf(w, "listener:\t%s %s\n", st.Listener, st.Addr)
+	cb := "ok"
+	if !st.Clipboard.Available {
+		cb = "unavailable: " + st.Clipboard.Error
 	}
-	var out struct {
-		ID string `json:"file_id"`
+	if st.Clipboard.Watch != "" {
+		cb += " (change detection: " + st.Clipboard.Watch + ")"
+	}
+	if st.Clipboard.Primary {
+		cb += ", PRIMARY synced"
+	}
+	fmt.Fprintf(w, "clipboard:\t%s\n", cb)
+	if s := st.Sync; s != nil {
+		state := s.Mode
+		if s.Paused {
+			state += ", paused"
+		}
+		if !s.Enabled {
+			state += ", not sending (no -sync)"
+		}
+		if s.Topology != "" {
+			state += ", topology " + s.Topology + ", transport " + s.Transport
+		}
+		fmt.Fprintf(w, "sync:\t%s\n", state)
+		fmt.Fprintf(w, "\tsent %d (last %s), received %d (last %s), failed %d\n",
+			s.Sent, ago(s.LastSent), s.Received, ago(s.LastReceived), s.Failed)
+	}
+	if d := st.Discovery; d != nil {
+		fmt.Fprintf(w, "discovery:\t%s, updated %s\n", d.Providers, ago(d.UpdatedAt))
+		if d.LastError != "" {
+			fmt.Fprintf(w, "\tlast error %s: %s\n", ago(d.LastErrorAt), d.LastError)
+		}
+	}
+	fmt.Fprintf(w, "pending:\t%d\n", st.Pending)
+	fmt.Fprintf(w, "files:\t%d (%s) in %s\n", st.Files.Count, byteSize(st.Files.Bytes), st.Files.Dir)
+	w.Flush()
+	if len(st.Peers) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tURL\tSTATE\tLAST CONTACT\tLAST ERROR")
+	for _, p := range st.Peers {
+		state := "online"
+		if p.Offline {
+			state = "offline"
+		}
+		lastErr := "-"
+		if p.LastError != "" {
+			lastErr = ago(p.LastErrorAt) + ": " + p.LastError
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Name, p.URL, state, ago(p.LastContact), lastErr)
+	}
+	w.Flush()
+}
+
+// ago formats t relative to now ("never" for the zero time).
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+// byteSize formats n bytes in KiB/MiB/GiB.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		log.Fatalf("decode: %v", err)
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
 	}
-	fmt.Printf("file uploaded to %s, id=%s\n", peer, out.ID)
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/clipboard"
+	"github.com/xconnect/xconnect-go/internal/discovery"
+	"github.com/xconnect/xconnect-go/pkg/client"
+	"tailscale.com/client/tailscale"
+)
+
+// doctor prints the result of each check with a fix for problems.
+type doctor struct {
+	fails, warns int
+}
+
+func (d *doctor) ok(check, format string, args ...any) {
+	fmt.Printf("[ ok ] %s: %s\n", check, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(check, msg, fix string) {
+	d.warns++
+	d.report("warn", check, msg, fix)
+}
+
+func (d *doctor) fail(check, msg, fix string) {
+	d.fails++
+	d.report("FAIL", check, msg, fix)
+}
+
+func (d *doctor) report(level, check, msg, fix string) {
+	fmt.Printf("[%s] %s: %s\n", level, check, msg)
+	if fix != "" {
+		fmt.Printf("       fix: %s\n", strings.ReplaceAll(fix, "\n", "\n            "))
+	}
+}
+
+// runDoctor checks the local server's port, the clipboard, Tailscale, discovery, the
+// reachability of peer (or every discovered peer) and the file directories.
+func runDoctor(rest []string) {
+	d := &doctor{}
+	up := d.port()
+	d.clipboard(up)
+	d.tailscale()
+	devices := d.discovery()
+	if len(rest) > 0 {
+		d.peer(rest[0], peerClient(rest[0]), true)
+	} else {
+		for _, dev := range devices {
+			u := discovery.BaseURL(dev, *port)
+			if dev.Offline || u == "" {
+				continue
+			}
+			d.peer(dev.HostName, client.New(u), false)
+		}
+	}
+	d.dirs(up)
+	fmt.Printf("\n%d problem(s), %d warning(s)\n", d.fails, d.warns)
+	if d.fails > 0 {
+		os.Exit(1)
+	}
+}
+
+// clipboard writes a marker to the clipboard, reads it back and restores the content.
+// With the server up it only reads: a running sync would send both writes to peers.
+func (d *doctor) clipboard(up bool) {
+	const check = "clipboard"
+	if err := clipboard.Check(); errors.Is(err, clipboard.ErrNoBackend) {
+		d.fail(check, err.Error(), clipboard.InstallHint())
+		return
+	}
+	if up {
+		if _, err := clipboard.ReadAll(); err != nil {
+			d.fail(check, "read: "+errors.Unwrap(err).Error(), clipboard.InstallHint())
+			return
+		}
+		d.ok(check, "read works (write test skipped while the server runs, so peers are not sent the test content)")
+		return
+	}
+	orig, origErr := clipboard.ReadAll() // may fail on an empty clipboard
+	marker := fmt.Sprintf("xconnect doctor %d", time.Now().UnixNano())
+	if err := clipboard.WriteAll(marker); err != nil {
+		d.fail(check, "write: "+errors.Unwrap(err).Error(), clipboard.InstallHint())
+		return
+	}
+	got, err := clipboard.ReadAll()
+	if origErr == nil {
+		defer clipboard.WriteAll(orig)
+	}
+	switch {
+	case err != nil:
+		d.fail(check, "read: "+errors.Unwrap(err).Error(), clipboard.InstallHint())
+	case got != marker:
+		d.fail(check, "read back different content than written",
+			"a clipboard manager may be rewriting the clipboard; run xconnect in the logged-in desktop session")
+	default:
+		d.ok(check, "read/write round trip works")
+	}
+}
+
+// tailscale checks the system tailscaled, MagicDNS and the format of TS_AUTHKEY.
+func (d *doctor) tailscale() {
+	const check = "tailscale"
+	if key := os.Getenv("TS_AUTHKEY"); key != "" {
+		if strings.HasPrefix(key, "tskey-") {
+			d.ok("TS_AUTHKEY", "set and looks like an auth key (not validated here; xconnect -tsnet fails to join the tailnet with a bad key)")
+		} else {
+			d.fail("TS_AUTHKEY", "does not look like an auth key (want tskey-auth-...)",
+				"generate one at https://login.tailscale.com/admin/settings/keys")
+		}
+	}
+	ctx, cancel := timeout()
+	defer cancel()
+	st, err := (&tailscale.LocalClient{}).Status(ctx)
+	if err != nil {
+		d.warn(check, "tailscaled not reachable: "+err.Error(),
+			"install and start Tailscale (https://tailscale.com/download), or run the server with -tsnet\n(with -tsnet or -discovery mdns this is expected)")
+		return
+	}
+	switch st.BackendState {
+	case "Running":
+		self := ""
+		if st.Self != nil {
+			self = strings.TrimSuffix(st.Self.DNSName, ".")
+		}
+		d.ok(check, "running as %s", self)
+	case "NeedsLogin", "NeedsMachineAuth":
+		fix := "run: tailscale up"
+		if st.AuthURL != "" {
+			fix = "open " + st.AuthURL
+		}
+		d.fail(check, "not logged in ("+st.BackendState+")", fix)
+		return
+	default:
+		d.fail(check, "state "+st.BackendState, "run: tailscale up")
+		return
+	}
+	if st.CurrentTailnet != nil && !st.CurrentTailnet.MagicDNSEnabled {
+		d.warn("magicdns", "MagicDNS is off, so peers cannot be reached by hostname",
+			"enable MagicDNS in the admin console (DNS page), or use 100.x.y.z addresses")
+	} else if st.CurrentTailnet != nil {
+		d.ok("magicdns", "enabled (%s)", st.CurrentTailnet.MagicDNSSuffix)
+	}
+	for _, h := range st.Health {
+		d.warn(check, h, "")
+	}
+}
+
+// discovery lists the devices with the -discovery providers.
+func (d *doctor) discovery() []discovery.Device {
+	const check = "discovery"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p, err := provider()
+	if err != nil {
+		d.fail(check, err.Error(), "check the -discovery flags")
+		return nil
+	}
+	devices, err := discovery.AllDevices(ctx, p)
+	if err != nil {
+		d.fail(check, err.Error(),
+			"check `tailscale status`, or pass -discovery api with TAILSCALE_API_TOKEN, -discovery file with -peers-file, or -discovery mdns")
+		return nil
+	}
+	online := 0
+	for _, dev := range devices {
+		if !dev.Offline {
+			online++
+		}
+	}
+	d.ok(check, "%d device(s), %d online, via %s", len(devices), online, p.Name())
+	return devices
+}
+
+// port checks that the local xconnect server answers at localBase; it reports whether
+// it is up. Only when it does not is -port bound, to tell a free port from another program.
+func (d *doctor) port() bool {
+	check := "port " + *port
+	ctx, cancel := timeout()
+	defer cancel()
+	h, herr := client.New(localBase()).Hello(ctx)
+	if herr == nil {
+		d.ok(check, "xconnect server running (protocol %d)", h.Protocol)
+		return true
+	}
+	ln, err := net.Listen("tcp", ":"+*port)
+	if err == nil {
+		ln.Close()
+		d.warn(check, "nothing listens here; the xconnect server is not running",
+			"start it: xconnect -sync (or xconnect -daemon -sync)\n(a server with -tsnet listens on the tailnet only: set XCONNECT_API to its tailnet address)")
+		return false
+	}
+	d.fail(check, "in use, but not by xconnect ("+herr.Error()+")",
+		"stop the other program, or run xconnect -addr :<port> and pass -port <port> here")
+	return false
+}
+
+// peer checks name resolution (for named peers), the TCP port and the handshake of peer.
+func (d *doctor) peer(name string, c *client.Client, resolve bool) {
+	check := "peer " + name
+	if resolve && net.ParseIP(name) == nil {
+		if _, err := net.LookupHost(name); err != nil {
+			d.fail(check, "cannot resolve: "+err.Error(),
+				"enable MagicDNS, use the peer's 100.x.y.z address, or check the name with `xconnect list`")
+			return
+		}
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(c.BaseURL, "http://"), "https://")
+	conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+	if err != nil {
+		d.fail(check, "port not reachable: "+err.Error(),
+			"start xconnect on the peer, and check that the tailnet ACL allows this device to reach its port "+*port)
+		return
+	}
+	conn.Close()
+	ctx, cancel := timeout()
+	defer cancel()
+	h, err := c.Hello(ctx)
+	if err != nil {
+		d.fail(check, "handshake: "+err.Error(), "upgrade xconnect on the older side")
+		return
+	}
+	if h.Protocol == 0 {
+		d.warn(check, "reachable, but runs a release from before the handshake", "upgrade xconnect on the peer")
+		return
+	}
+	d.ok(check, "reachable, protocol %d, %s", h.Protocol, strings.Join(h.Capabilities, ","))
+}
+
+// dirs checks that the received files and outbox directories are writable.
+func (d *doctor) dirs(up bool) {
+	files := "xconnect-files" // relative to the server's working directory
+	if up {
+		ctx, cancel := timeout()
+		defer cancel()
+		if st, err := client.New(localBase()).Status(ctx); err == nil && st.Files.Dir != "" {
+			files = st.Files.Dir
+		}
+	}
+	d.writable("files dir", files)
+	if *outboxDir != "" {
+		d.writable("outbox", *outboxDir)
+	}
+}
+
+// writable creates and removes a file in dir, or in its nearest existing parent when
+// dir does not exist yet (it is created on first use).
+func (d *doctor) writable(check, dir string) {
+	probe := dir
+	for {
+		if _, err := os.Stat(probe); !errors.Is(err, os.ErrNotExist) || filepath.Dir(probe) == probe {
+			break
+		}
+		probe = filepath.Dir(probe)
+	}
+	f, err := os.CreateTemp(probe, ".xconnect-doctor-*")
+	if err != nil {
+		d.fail(check, dir+" is not writable: "+err.Error(),
+			"fix the permissions (chmod u+w) or run xconnect as the directory's owner")
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.ok(check, "%s is writable", dir)
+}
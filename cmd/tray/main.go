@@ -3,10 +3,10 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -14,27 +14,29 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/widget"
+
+	"github.com/xconnect/xconnect-go/pkg/client"
 )
 
 const defaultAPIBase = "http://127.0.0.1:8315"
 
-type clipboardEntry struct {
-	Content  string    `json:"content"`
-	FromHost string    `json:"from_host"`
-	At       time.Time `json:"at"`
-}
-
 func main() {
 	apiBase := os.Getenv("XCONNECT_API")
 	if apiBase == "" {
 		apiBase = defaultAPIBase
 	}
+	// 握手：协商 API 版本（/v1）与服务端功能；服务未启动时按旧路径访问、功能全开
+	api := client.New(apiBase)
+	ctx, cancel := timeout()
+	hello, herr := api.Hello(ctx)
+	cancel()
+	has := func(c string) bool { return herr != nil || hello.Has(c) }
 
 	a := app.New()
 	w := a.NewWindow("XConnect 剪贴板历史")
 	w.Resize(fyne.NewSize(520, 400))
 
-	var historyEntries []clipboardEntry
+	var historyEntries []client.HistoryEntry
 	list := widget.NewList(
 		func() int { return len(historyEntries) },
 		func() fyne.CanvasObject {
@@ -62,10 +64,15 @@ func main() {
 	)
 	status := widget.NewLabel("点击「刷新」从服务拉取历史")
 	status.Wrapping = fyne.TextWrapWord
+	if herr != nil && !client.IsUnreachable(herr) {
+		status.SetText("版本不兼容: " + herr.Error())
+	}
 
 	refresh := func() {
 		status.SetText("正在加载…")
-		entries, err := fetchHistory(apiBase)
+		ctx, cancel := timeout()
+		entries, err := api.History(ctx)
+		cancel()
 		if err != nil {
 			status.SetText("加载失败: " + err.Error())
 			list.Refresh()
@@ -86,30 +93,118 @@ func main() {
 	})
 
 	if desk, ok := a.(desktop.App); ok {
-		m := fyne.NewMenu("XConnect",
-			fyne.NewMenuItem("显示主窗口", func() { w.Show() }),
-			fyne.NewMenuItemSeparator(),
-			fyne.NewMenuItem("退出", func() { a.Quit() }),
-		)
-		desk.SetSystemTrayMenu(m)
+		pause := fyne.NewMenuItem("暂停同步", nil)
+		pause.Disabled = !has(client.CapSyncControl)
+		var pending []client.Pending
+		var m *fyne.Menu
+		// 菜单：待确认的剪贴板（-accept ask）各占一项，子菜单中接受或拒绝
+		buildMenu := func() {
+			items := []*fyne.MenuItem{
+				fyne.NewMenuItem("显示主窗口", func() { w.Show() }),
+				pause,
+			}
+			if len(pending) > 0 {
+				items = append(items, fyne.NewMenuItemSeparator())
+			}
+			for _, p := range pending {
+				p := p
+				item := fyne.NewMenuItem(fmt.Sprintf("来自 %s: %s", p.From, preview(p.Content, 30)), nil)
+				item.ChildMenu = fyne.NewMenu("",
+					fyne.NewMenuItem("接受", func() { decidePending(api, p.ID, "accept", status) }),
+					fyne.NewMenuItem("拒绝", func() { decidePending(api, p.ID, "reject", status) }),
+				)
+				items = append(items, item)
+			}
+			items = append(items, fyne.NewMenuItemSeparator(), fyne.NewMenuItem("退出", func() { a.Quit() }))
+			m = fyne.NewMenu("XConnect", items...)
+			desk.SetSystemTrayMenu(m)
+		}
+		// 暂停/恢复同步：勾选状态以服务端 /sync/status 为准
+		setPaused := func(st client.SyncStatus, err error) {
+			if err != nil {
+				status.SetText("同步控制失败: " + err.Error())
+				return
+			}
+			pause.Checked = st.Paused
+			m.Refresh()
+		}
+		pause.Action = func() {
+			ctx, cancel := timeout()
+			defer cancel()
+			if pause.Checked {
+				setPaused(api.ResumeSync(ctx))
+			} else {
+				setPaused(api.PauseSync(ctx))
+			}
+		}
+		buildMenu()
+		ctx, cancel := timeout()
+		if st, err := api.SyncStatus(ctx); err == nil {
+			setPaused(st, nil)
+		}
+		cancel()
+		// 定期拉取待确认列表，有变化时重建菜单
+		go func() {
+			if !has(client.CapPending) {
+				return
+			}
+			for range time.Tick(3 * time.Second) {
+				ctx, cancel := timeout()
+				list, err := api.Pending(ctx)
+				cancel()
+				if err != nil || samePending(list, pending) {
+					continue
+				}
+				pending = list
+				buildMenu()
+			}
+		}()
 	}
 
 	w.ShowAndRun()
 }
 
-func fetchHistory(apiBase string) ([]clipboardEntry, error) {
-	url := apiBase + "/clipboard/history"
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
+// timeout 单次 API 调用的超时
+func timeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+func samePending(a, b []client.Pending) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
 	}
-	var entries []clipboardEntry
-	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
-		return nil, err
+	return true
+}
+
+// decidePending 调用 POST /v1/clipboard/pending/{id}/accept|reject，结果显示在状态栏
+func decidePending(api *client.Client, id, action string, status *widget.Label) {
+	ctx, cancel := timeout()
+	defer cancel()
+	decide := api.Reject
+	if action == "accept" {
+		decide = api.Accept
+	}
+	if _, err := decide(ctx, id); err != nil {
+		status.SetText("操作失败: " + err.Error())
+		return
+	}
+	if action == "accept" {
+		status.SetText("已接受剪贴板内容")
+	} else {
+		status.SetText("已拒绝剪贴板内容")
+	}
+}
+
+// preview 截取前 n 个字符（按 rune），换行折叠为空格
+func preview(s string, n int) string {
+	r := []rune(strings.Join(strings.Fields(s), " "))
+	if len(r) > n {
+		return string(r[:n]) + "…"
 	}
-	return entries, nil
+	return string(r)
 }
@@ -0,0 +1,214 @@
+// Package approval decides, per peer, whether incoming clipboard content is written
+// at once, held for the user to accept, or rejected.
+package approval
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions.
+const (
+	Auto   = "auto"   // write at once
+	Ask    = "ask"    // hold until accepted
+	Reject = "reject" // never write
+)
+
+// Rule applies Action to peers whose name or address matches Pattern (a glob).
+type Rule struct {
+	Pattern string
+	Action  string
+}
+
+// Policy maps peers to actions; the first matching rule wins, else Default.
+type Policy struct {
+	Default string
+	Rules   []Rule
+}
+
+// ParsePolicy parses a default action and comma-separated "pattern=action" rules,
+// e.g. ParsePolicy("ask", "laptop=auto,phone-*=ask,100.64.0.9=reject").
+func ParsePolicy(def, rules string) (*Policy, error) {
+	if def == "" {
+		def = Auto
+	}
+	if err := checkAction(def); err != nil {
+		return nil, err
+	}
+	p := &Policy{Default: def}
+	for _, r := range strings.Split(rules, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		pat, action, ok := strings.Cut(r, "=")
+		if !ok {
+			return nil, fmt.Errorf("rule %q: want pattern=action", r)
+		}
+		pat, action = strings.ToLower(strings.TrimSpace(pat)), strings.TrimSpace(action)
+		if _, err := path.Match(pat, ""); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r, err)
+		}
+		if err := checkAction(action); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r, err)
+		}
+		p.Rules = append(p.Rules, Rule{Pattern: pat, Action: action})
+	}
+	return p, nil
+}
+
+func checkAction(a string) error {
+	switch a {
+	case Auto, Ask, Reject:
+		return nil
+	}
+	return fmt.Errorf("unknown action %q (want %s, %s or %s)", a, Auto, Ask, Reject)
+}
+
+// For returns the action for a peer known by names (hostname, address).
+func (p *Policy) For(names ...string) string {
+	for _, r := range p.Rules {
+		for _, n := range names {
+			if n == "" {
+				continue
+			}
+			if ok, _ := path.Match(r.Pattern, strings.ToLower(n)); ok {
+				return r.Action
+			}
+		}
+	}
+	return p.Default
+}
+
+// Pending is an incoming clipboard update waiting for the user.
+type Pending struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Content string    `json:"content"`
+	At      time.Time `json:"at"`
+	Expires time.Time `json:"expires"`
+
+	apply func() error
+}
+
+// ErrNotFound is returned for an unknown or expired pending ID.
+var ErrNotFound = errors.New("no such pending item")
+
+// Queue holds pending updates until they are accepted, rejected or expire.
+type Queue struct {
+	TTL time.Duration // default 2m; change with SetTTL once in use
+	Max int           // default 20; the oldest is discarded when full
+	// OnAdd, if set, is called for every new pending item (e.g. to notify the user).
+	OnAdd func(Pending)
+
+	mu    sync.Mutex
+	items []*Pending
+}
+
+// Add holds content from peer; apply writes it when accepted. An item with the same
+// sender and content replaces the older one.
+func (q *Queue) Add(from, content string, apply func() error) Pending {
+	b := make([]byte, 6)
+	rand.Read(b)
+	now := time.Now()
+
+	q.mu.Lock()
+	ttl, max := q.TTL, q.Max
+	if ttl <= 0 {
+		ttl = 2 * time.Minute
+	}
+	if max <= 0 {
+		max = 20
+	}
+	p := &Pending{ID: hex.EncodeToString(b), From: from, Content: content, At: now, Expires: now.Add(ttl), apply: apply}
+	q.expire(now)
+	for i, it := range q.items {
+		if it.From == from && it.Content == content {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	if len(q.items) >= max {
+		slog.Warn("approval: queue full, discarding oldest", "from", q.items[0].From)
+		q.items = q.items[1:]
+	}
+	q.items = append(q.items, p)
+	q.mu.Unlock()
+
+	if q.OnAdd != nil {
+		q.OnAdd(*p)
+	}
+	return *p
+}
+
+// SetTTL changes how long new items are held; held items keep their deadline.
+func (q *Queue) SetTTL(ttl time.Duration) {
+	q.mu.Lock()
+	q.TTL = ttl
+	q.mu.Unlock()
+}
+
+// expire drops items past their deadline; q.mu must be held.
+func (q *Queue) expire(now time.Time) {
+	n := 0
+	for _, it := range q.items {
+		if now.After(it.Expires) {
+			slog.Info("approval: pending clipboard expired", "from", it.From)
+			continue
+		}
+		q.items[n] = it
+		n++
+	}
+	q.items = q.items[:n]
+}
+
+// List returns the pending items, newest first.
+func (q *Queue) List() []Pending {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire(time.Now())
+	list := make([]Pending, 0, len(q.items))
+	for i := len(q.items) - 1; i >= 0; i-- {
+		list = append(list, *q.items[i])
+	}
+	return list
+}
+
+// take removes and returns the item with id, or the newest when id is empty.
+func (q *Queue) take(id string) (*Pending, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire(time.Now())
+	for i := len(q.items) - 1; i >= 0; i-- {
+		if it := q.items[i]; id == "" || it.ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return it, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Accept writes the item with id (the newest when empty) and removes it.
+func (q *Queue) Accept(id string) (Pending, error) {
+	it, err := q.take(id)
+	if err != nil {
+		return Pending{}, err
+	}
+	return *it, it.apply()
+}
+
+// Reject discards the item with id (the newest when empty).
+func (q *Queue) Reject(id string) (Pending, error) {
+	it, err := q.take(id)
+	if err != nil {
+		return Pending{}, err
+	}
+	return *it, nil
+}
@@ -0,0 +1,130 @@
+package approval
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("", " Laptop = auto , phone-*=ask,,100.64.0.9=reject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Policy{Default: Auto, Rules: []Rule{{"laptop", Auto}, {"phone-*", Ask}, {"100.64.0.9", Reject}}}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("ParsePolicy = %+v, want %+v", p, want)
+	}
+
+	for _, tt := range []struct{ def, rules, err string }{
+		{"never", "", `unknown action "never"`},
+		{"ask", "laptop", `rule "laptop": want pattern=action`},
+		{"ask", "laptop=maybe", `rule "laptop=maybe": unknown action "maybe"`},
+		{"ask", "[a=auto", `rule "[a=auto": syntax error in pattern`},
+	} {
+		if _, err := ParsePolicy(tt.def, tt.rules); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParsePolicy(%q, %q): err = %v, want %q", tt.def, tt.rules, err, tt.err)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	p, err := ParsePolicy("ask", "laptop=auto,phone-*=reject,100.64.*=auto,*=ask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Rules = p.Rules[:3] // without the catch-all, to see Default
+	for _, tt := range []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"laptop"}, Auto},
+		{[]string{"LAPTOP"}, Auto},
+		{[]string{"phone-anna"}, Reject},
+		{[]string{"tablet", "100.64.0.7"}, Auto},
+		{[]string{"", "phone-x"}, Reject},
+		{[]string{"laptop.local"}, Ask},
+		{nil, Ask},
+	} {
+		if got := p.For(tt.names...); got != tt.want {
+			t.Errorf("For(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+
+	// The first matching rule wins, across all names.
+	p, _ = ParsePolicy("auto", "phone-*=reject,100.64.*=auto")
+	if got := p.For("100.64.0.7", "phone-x"); got != Reject {
+		t.Errorf("For = %q, want the first rule's reject", got)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	var added []string
+	q := &Queue{Max: 2, OnAdd: func(p Pending) { added = append(added, p.Content) }}
+	var applied []string
+	apply := func(s string) func() error {
+		return func() error { applied = append(applied, s); return nil }
+	}
+
+	first := q.Add("laptop", "one", apply("one"))
+	q.Add("phone", "two", apply("two"))
+	if got := contents(q.List()); !reflect.DeepEqual(got, []string{"two", "one"}) {
+		t.Errorf("List = %q, want newest first", got)
+	}
+
+	// The same sender and content replaces the held item.
+	again := q.Add("laptop", "one", apply("one again"))
+	if got := contents(q.List()); !reflect.DeepEqual(got, []string{"one", "two"}) {
+		t.Errorf("after a duplicate: List = %q", got)
+	}
+	if _, err := q.Accept(first.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Accept of a replaced item: err = %v, want ErrNotFound", err)
+	}
+
+	// A full queue discards the oldest.
+	q.Add("tablet", "three", apply("three"))
+	if got := contents(q.List()); !reflect.DeepEqual(got, []string{"three", "one"}) {
+		t.Errorf("after Max: List = %q", got)
+	}
+
+	if p, err := q.Accept(again.ID); err != nil || p.Content != "one" {
+		t.Errorf("Accept = %+v, %v", p, err)
+	}
+	if p, err := q.Reject(""); err != nil || p.Content != "three" {
+		t.Errorf("Reject of the newest = %+v, %v", p, err)
+	}
+	if _, err := q.Reject(""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Reject on an empty queue: err = %v", err)
+	}
+	if !reflect.DeepEqual(applied, []string{"one again"}) {
+		t.Errorf("applied %q, want only the accepted item", applied)
+	}
+	if !reflect.DeepEqual(added, []string{"one", "two", "one", "three"}) {
+		t.Errorf("OnAdd saw %q", added)
+	}
+}
+
+func TestQueueExpiry(t *testing.T) {
+	q := &Queue{TTL: 20 * time.Millisecond}
+	old := q.Add("laptop", "old", func() error { return nil })
+	q.SetTTL(time.Hour)
+	q.Add("laptop", "new", func() error { return nil })
+	time.Sleep(50 * time.Millisecond)
+
+	if got := contents(q.List()); !reflect.DeepEqual(got, []string{"new"}) {
+		t.Errorf("List = %q, want the expired item gone", got)
+	}
+	if _, err := q.Accept(old.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Accept of an expired item: err = %v, want ErrNotFound", err)
+	}
+}
+
+func contents(list []Pending) []string {
+	var s []string
+	for _, p := range list {
+		s = append(s, p.Content)
+	}
+	return s
+}
@@ -1,15 +1,46 @@
 package clipboard
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
+	"sync"
 
 	"github.com/atotto/clipboard"
 )
 
+// The system clipboard; UseMemory replaces it.
+var (
+	readAll     = clipboard.ReadAll
+	writeAll    = clipboard.WriteAll
+	unsupported = func() bool { return clipboard.Unsupported }
+)
+
+// UseMemory replaces the system clipboard with an in-memory one until restore is
+// called, so tests can serve the API on machines without a clipboard. It is not
+// safe to call while the clipboard is in use.
+func UseMemory() (restore func()) {
+	var mu sync.Mutex
+	var text string
+	r, w, u := readAll, writeAll, unsupported
+	readAll = func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return text, nil
+	}
+	writeAll = func(s string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		text = s
+		return nil
+	}
+	unsupported = func() bool { return false }
+	return func() { readAll, writeAll, unsupported = r, w, u }
+}
+
 // ReadAll returns the clipboard content, or a user-friendly error including install hints.
 func ReadAll() (string, error) {
-	s, err := clipboard.ReadAll()
+	s, err := readAll()
 	if err != nil {
 		return "", fmt.Errorf("%w\n%s", err, installHint("read"))
 	}
@@ -18,12 +49,30 @@ func ReadAll() (string, error) {
 
 // WriteAll writes content to the clipboard, or returns a user-friendly error including install hints.
 func WriteAll(content string) error {
-	if err := clipboard.WriteAll(content); err != nil {
+	if err := writeAll(content); err != nil {
 		return fmt.Errorf("%w\n%s", err, installHint("write"))
 	}
 	return nil
 }
 
+// ErrNoBackend is returned by Check when no clipboard utility was found.
+var ErrNoBackend = errors.New("no clipboard utility found")
+
+// Check reports whether the clipboard can be read: nil, ErrNoBackend, or the error
+// of a test read. Unlike ReadAll, the error carries no install hints.
+func Check() error {
+	if unsupported() {
+		return ErrNoBackend
+	}
+	_, err := readAll()
+	return err
+}
+
+// InstallHint returns how to install or fix the clipboard tools on this platform.
+func InstallHint() string {
+	return installHint("")
+}
+
 // installHint returns platform-specific install instructions for clipboard tools.
 func installHint(op string) string {
 	switch runtime.GOOS {
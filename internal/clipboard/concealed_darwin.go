@@ -0,0 +1,21 @@
+//go:build darwin
+
+package clipboard
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+// Concealed reports whether the current pasteboard content carries the
+// org.nspasteboard.ConcealedType marker that password managers set. It asks
+// NSPasteboard through osascript (JavaScript for Automation), since reading
+// pasteboard types directly needs cgo.
+func Concealed() bool {
+	out, err := exec.Command("osascript", "-l", "JavaScript", "-e",
+		`ObjC.import("AppKit"); ObjC.deepUnwrap($.NSPasteboard.generalPasteboard.types).join("\n")`).Output()
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(out, []byte("org.nspasteboard.ConcealedType"))
+}
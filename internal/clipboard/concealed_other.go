@@ -0,0 +1,8 @@
+//go:build !linux && !freebsd && !netbsd && !openbsd && !windows && !darwin
+
+package clipboard
+
+// Concealed always reports false: the platform has no known password hint.
+func Concealed() bool {
+	return false
+}
@@ -0,0 +1,33 @@
+//go:build linux || freebsd || netbsd || openbsd
+
+package clipboard
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+)
+
+// passwordManagerHint is the MIME type KeePassXC, KDE Klipper and others attach to
+// copied passwords (value "secret").
+const passwordManagerHint = "x-kde-passwordManagerHint"
+
+// Concealed reports whether the current clipboard content is marked as a password
+// by the copying app. It lists the offered types with wl-paste or xclip; false when
+// neither is available.
+func Concealed() bool {
+	var cmd *exec.Cmd
+	switch {
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		cmd = exec.Command("wl-paste", "--list-types")
+	case os.Getenv("DISPLAY") != "":
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-t", "TARGETS", "-o")
+	default:
+		return false
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(out, []byte(passwordManagerHint))
+}
@@ -0,0 +1,37 @@
+//go:build windows
+
+package clipboard
+
+import (
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procRegisterClipboardFormatW   = user32.NewProc("RegisterClipboardFormatW")
+	procIsClipboardFormatAvailable = user32.NewProc("IsClipboardFormatAvailable")
+
+	concealedOnce    sync.Once
+	concealedFormats []uintptr
+)
+
+// Concealed reports whether the current clipboard content is excluded from clipboard
+// monitors by the copying app (password managers set these formats on passwords).
+func Concealed() bool {
+	concealedOnce.Do(func() {
+		for _, name := range []string{"ExcludeClipboardContentFromMonitorProcessing", "Clipboard Viewer Ignore"} {
+			p, _ := windows.UTF16PtrFromString(name)
+			if f, _, _ := procRegisterClipboardFormatW.Call(uintptr(unsafe.Pointer(p))); f != 0 {
+				concealedFormats = append(concealedFormats, f)
+			}
+		}
+	})
+	for _, f := range concealedFormats {
+		if r, _, _ := procIsClipboardFormatAvailable.Call(f); r != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package clipboard
+
+import "errors"
+
+// Selections that can be watched and synced.
+const (
+	SelectionClipboard = "clipboard" // copy/paste (Ctrl+C / Ctrl+V)
+	SelectionPrimary   = "primary"   // X11/Wayland PRIMARY: selected text, pasted with middle-click
+)
+
+// ErrNoPrimary is returned when the PRIMARY selection is not available: not on
+// X11/Wayland, or no selection tool is installed.
+var ErrNoPrimary = errors.New("PRIMARY selection not available (needs wl-clipboard, xclip or xsel on Linux/BSD)")
@@ -0,0 +1,18 @@
+//go:build !linux && !freebsd && !netbsd && !openbsd
+
+package clipboard
+
+// PrimaryAvailable reports false: there is no PRIMARY selection on this platform.
+func PrimaryAvailable() bool {
+	return false
+}
+
+// ReadPrimary returns ErrNoPrimary.
+func ReadPrimary() (string, error) {
+	return "", ErrNoPrimary
+}
+
+// WritePrimary returns ErrNoPrimary.
+func WritePrimary(content string) error {
+	return ErrNoPrimary
+}
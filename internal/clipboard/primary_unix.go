@@ -0,0 +1,75 @@
+//go:build linux || freebsd || netbsd || openbsd
+
+package clipboard
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type selectionTool struct {
+	name        string
+	read, write []string
+}
+
+// primaryTools lists the PRIMARY selection tools to try, Wayland first when in a
+// Wayland session.
+func primaryTools() []selectionTool {
+	var tools []selectionTool
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, selectionTool{"wl-paste", []string{"wl-paste", "--primary", "--no-newline"}, []string{"wl-copy", "--primary"}})
+	}
+	if os.Getenv("DISPLAY") != "" {
+		tools = append(tools,
+			selectionTool{"xclip", []string{"xclip", "-selection", "primary", "-o"}, []string{"xclip", "-selection", "primary", "-i"}},
+			selectionTool{"xsel", []string{"xsel", "--primary", "--output"}, []string{"xsel", "--primary", "--input"}},
+		)
+	}
+	return tools
+}
+
+func primaryTool() (selectionTool, bool) {
+	for _, t := range primaryTools() {
+		if _, err := exec.LookPath(t.read[0]); err != nil {
+			continue
+		}
+		if _, err := exec.LookPath(t.write[0]); err != nil {
+			continue
+		}
+		return t, true
+	}
+	return selectionTool{}, false
+}
+
+// PrimaryAvailable reports whether ReadPrimary and WritePrimary can work here.
+func PrimaryAvailable() bool {
+	_, ok := primaryTool()
+	return ok
+}
+
+// ReadPrimary returns the PRIMARY selection. An empty selection is returned as "".
+func ReadPrimary() (string, error) {
+	t, ok := primaryTool()
+	if !ok {
+		return "", ErrNoPrimary
+	}
+	out, err := exec.Command(t.read[0], t.read[1:]...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", nil // no selection owner
+	}
+	return string(out), err
+}
+
+// WritePrimary sets the PRIMARY selection, so content can be pasted with middle-click.
+func WritePrimary(content string) error {
+	t, ok := primaryTool()
+	if !ok {
+		return ErrNoPrimary
+	}
+	cmd := exec.Command(t.write[0], t.write[1:]...)
+	cmd.Stdin = strings.NewReader(content)
+	return cmd.Run()
+}
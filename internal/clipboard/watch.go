@@ -0,0 +1,149 @@
+package clipboard
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	// PollInterval is used by the polling fallback (default 1s).
+	PollInterval time.Duration
+	// PollOnly disables change-notification backends.
+	PollOnly bool
+	// Selection to watch: SelectionClipboard (default) or SelectionPrimary.
+	Selection string
+}
+
+// Watcher signals on C whenever the clipboard may have changed. Notifications are
+// coalesced: C has a buffer of one, so a slow reader sees one pending signal rather
+// than a backlog. Readers should read the clipboard and compare on each signal.
+type Watcher struct {
+	C <-chan struct{}
+
+	c       chan struct{}
+	mu      sync.Mutex
+	backend string
+}
+
+// Backend returns the name of the active backend, e.g. "wl-paste", "xfixes",
+// "win32-listener" or "poll"; "none" if ctx was done before one started.
+func (w *Watcher) Backend() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.backend
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.c <- struct{}{}:
+	default:
+	}
+}
+
+// watchBackend is a change-notification source. start returns an error when the
+// backend is unavailable; otherwise done yields once it stops. A backend with a
+// grace period may still fail after starting: it becomes active only once it has
+// sent a notification or stayed up for grace.
+type watchBackend struct {
+	name  string
+	start func(ctx context.Context, notify func()) (done <-chan error, err error)
+	grace time.Duration
+}
+
+// Watch starts watching the clipboard until ctx is done. It uses the first available
+// change-notification backend for the platform and falls back to polling every
+// PollInterval when none is available or the active one stops. One signal is sent
+// immediately so the caller picks up the current content.
+func Watch(ctx context.Context, opts WatchOptions) *Watcher {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	w := &Watcher{c: make(chan struct{}, 1)}
+	w.C = w.c
+	var backends []watchBackend
+	if !opts.PollOnly {
+		sel := opts.Selection
+		if sel == "" {
+			sel = SelectionClipboard
+		}
+		backends = platformBackends(sel)
+	}
+	ready := make(chan struct{})
+	go w.run(ctx, backends, opts.PollInterval, ready)
+	<-ready
+	w.notify()
+	return w
+}
+
+func (w *Watcher) setBackend(name string, ready chan struct{}) {
+	w.mu.Lock()
+	w.backend = name
+	w.mu.Unlock()
+	select {
+	case <-ready:
+	default:
+		close(ready)
+	}
+}
+
+func (w *Watcher) run(ctx context.Context, backends []watchBackend, interval time.Duration, ready chan struct{}) {
+	// Watch waits for ready; a ctx done before any backend was chosen must not hang it.
+	defer func() {
+		select {
+		case <-ready:
+		default:
+			w.setBackend("none", ready)
+		}
+	}()
+	for _, b := range backends {
+		first := make(chan struct{})
+		var once sync.Once
+		done, err := b.start(ctx, func() {
+			once.Do(func() { close(first) })
+			w.notify()
+		})
+		if err != nil {
+			continue
+		}
+		if b.grace > 0 {
+			t := time.NewTimer(b.grace)
+			stopped := false
+			select {
+			case <-first:
+			case <-t.C:
+			case err = <-done:
+				stopped = true
+			}
+			t.Stop()
+			if ctx.Err() != nil {
+				return
+			}
+			if stopped {
+				slog.Debug("clipboard: watcher unavailable", "backend", b.name, "err", err)
+				continue
+			}
+		}
+		w.setBackend(b.name, ready)
+		err = <-done
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("clipboard: watcher stopped; falling back", "backend", b.name, "err", err)
+		// Content may have changed while switching backends.
+		w.notify()
+	}
+	w.setBackend("poll", ready)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			w.notify()
+		}
+	}
+}
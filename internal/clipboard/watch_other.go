@@ -0,0 +1,9 @@
+//go:build !linux && !freebsd && !netbsd && !openbsd && !windows
+
+package clipboard
+
+// platformBackends: none. macOS exposes clipboard changes only through
+// NSPasteboard.changeCount, which needs cgo; Watch polls instead.
+func platformBackends(sel string) []watchBackend {
+	return nil
+}
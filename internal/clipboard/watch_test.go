@@ -0,0 +1,70 @@
+package clipboard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// silentBackend starts and then neither notifies nor stops until ctx is done.
+func silentBackend(grace time.Duration) watchBackend {
+	return watchBackend{name: "silent", grace: grace, start: func(ctx context.Context, notify func()) (<-chan error, error) {
+		done := make(chan error, 1)
+		go func() {
+			<-ctx.Done()
+			done <- ctx.Err()
+		}()
+		return done, nil
+	}}
+}
+
+func runWatcher(ctx context.Context, backends []watchBackend) *Watcher {
+	w := &Watcher{c: make(chan struct{}, 1)}
+	w.C = w.c
+	ready := make(chan struct{})
+	go w.run(ctx, backends, 10*time.Millisecond, ready)
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		panic("watcher never became ready")
+	}
+	return w
+}
+
+func TestWatchCancelledDuringGrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	w := runWatcher(ctx, []watchBackend{silentBackend(time.Hour)})
+	if b := w.Backend(); b != "none" {
+		t.Errorf("Backend = %q, want none", b)
+	}
+}
+
+func TestWatchFallsBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failing := watchBackend{name: "broken", start: func(context.Context, func()) (<-chan error, error) {
+		return nil, errors.New("unavailable")
+	}}
+	exiting := watchBackend{name: "exits", grace: time.Hour, start: func(context.Context, func()) (<-chan error, error) {
+		done := make(chan error, 1)
+		done <- errors.New("exited at once")
+		return done, nil
+	}}
+	w := runWatcher(ctx, []watchBackend{failing, exiting})
+	if b := w.Backend(); b != "poll" {
+		t.Errorf("Backend = %q, want poll", b)
+	}
+	select {
+	case <-w.C:
+	case <-time.After(time.Second):
+		t.Error("polling sent no signal")
+	}
+
+	// A backend that stays up past its grace counts as working.
+	w = runWatcher(ctx, []watchBackend{silentBackend(10 * time.Millisecond)})
+	if b := w.Backend(); b != "silent" {
+		t.Errorf("Backend = %q, want silent", b)
+	}
+}
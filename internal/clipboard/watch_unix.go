@@ -0,0 +1,121 @@
+//go:build linux || freebsd || netbsd || openbsd
+
+package clipboard
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xfixes"
+	"github.com/jezek/xgb/xproto"
+)
+
+// platformBackends: wl-paste --watch on Wayland, XFixes selection events on X11.
+func platformBackends(sel string) []watchBackend {
+	return []watchBackend{
+		{name: "wl-paste", start: func(ctx context.Context, notify func()) (<-chan error, error) {
+			return watchWlPaste(ctx, sel, notify)
+		}, grace: wlPasteGrace},
+		{name: "xfixes", start: func(ctx context.Context, notify func()) (<-chan error, error) {
+			return watchXFixes(ctx, sel, notify)
+		}},
+	}
+}
+
+// wlPasteGrace is how long wl-paste must stay up, if it reports no change first,
+// before it counts as working.
+const wlPasteGrace = time.Second
+
+// watchWlPaste runs `wl-paste --watch echo`, which prints a line on every clipboard
+// change. Needs a compositor with the wlr data-control protocol (not GNOME); on others
+// wl-paste exits at once and Watch falls back.
+func watchWlPaste(ctx context.Context, sel string, notify func()) (<-chan error, error) {
+	if os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil, errors.New("not a Wayland session")
+	}
+	path, err := exec.LookPath("wl-paste")
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--watch", "echo"}
+	if sel == SelectionPrimary {
+		args = append([]string{"--primary"}, args...)
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			notify()
+		}
+		done <- cmd.Wait()
+	}()
+	return done, nil
+}
+
+// watchXFixes subscribes to XFixes SetSelectionOwner events for CLIPBOARD (or PRIMARY)
+// on the root window; every copy (or new selection) makes the app the new owner.
+func watchXFixes(ctx context.Context, sel string, notify func()) (<-chan error, error) {
+	if os.Getenv("DISPLAY") == "" {
+		return nil, errors.New("no X11 display")
+	}
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := xfixes.Init(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := xfixes.QueryVersion(conn, 5, 0).Reply(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	name := "CLIPBOARD"
+	if sel == SelectionPrimary {
+		name = "PRIMARY"
+	}
+	atom, err := xproto.InternAtom(conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	root := xproto.Setup(conn).DefaultScreen(conn).Root
+	mask := uint32(xfixes.SelectionEventMaskSetSelectionOwner |
+		xfixes.SelectionEventMaskSelectionWindowDestroy |
+		xfixes.SelectionEventMaskSelectionClientClose)
+	if err := xfixes.SelectSelectionInputChecked(conn, root, atom.Atom, mask).Check(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		for {
+			ev, xerr := conn.WaitForEvent()
+			if ev == nil && xerr == nil {
+				done <- errors.New("X11 connection closed")
+				return
+			}
+			if _, ok := ev.(xfixes.SelectionNotifyEvent); ok {
+				notify()
+			}
+		}
+	}()
+	return done, nil
+}
@@ -0,0 +1,181 @@
+//go:build windows
+
+package clipboard
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// platformBackends: a clipboard format listener on a message-only window. Windows
+// has no PRIMARY selection.
+func platformBackends(sel string) []watchBackend {
+	if sel != SelectionClipboard {
+		return nil
+	}
+	return []watchBackend{{name: "win32-listener", start: watchFormatListener, grace: listenerGrace}}
+}
+
+// listenerGrace is how long the format listener must stay up, if it reports no change
+// first, before it counts as working.
+const listenerGrace = time.Second
+
+var (
+	user32                            = windows.NewLazySystemDLL("user32.dll")
+	procRegisterClassExW              = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW               = user32.NewProc("CreateWindowExW")
+	procDestroyWindow                 = user32.NewProc("DestroyWindow")
+	procDefWindowProcW                = user32.NewProc("DefWindowProcW")
+	procGetMessageW                   = user32.NewProc("GetMessageW")
+	procTranslateMessage              = user32.NewProc("TranslateMessage")
+	procDispatchMessageW              = user32.NewProc("DispatchMessageW")
+	procPostMessageW                  = user32.NewProc("PostMessageW")
+	procAddClipboardFormatListener    = user32.NewProc("AddClipboardFormatListener")
+	procRemoveClipboardFormatListener = user32.NewProc("RemoveClipboardFormatListener")
+)
+
+const (
+	wmClipboardUpdate = 0x031D
+	wmAppStop         = 0x8000 + 1  // WM_APP + 1
+	hwndMessage       = ^uintptr(2) // HWND_MESSAGE ((HWND)-3)
+)
+
+type wndClassEx struct {
+	size       uint32
+	style      uint32
+	wndProc    uintptr
+	clsExtra   int32
+	wndExtra   int32
+	instance   windows.Handle
+	icon       windows.Handle
+	cursor     windows.Handle
+	background windows.Handle
+	menuName   *uint16
+	className  *uint16
+	iconSm     windows.Handle
+}
+
+type winMsg struct {
+	hwnd    uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	pt      struct{ x, y int32 }
+}
+
+var (
+	classOnce sync.Once
+	classErr  error
+	className *uint16
+
+	listenersMu sync.Mutex
+	listeners   = map[uintptr]func(){} // hwnd -> notify
+)
+
+// wndProc is shared by all listener windows (callbacks are a limited resource).
+func wndProc(hwnd, msg, wParam, lParam uintptr) uintptr {
+	if msg == wmClipboardUpdate {
+		listenersMu.Lock()
+		notify := listeners[hwnd]
+		listenersMu.Unlock()
+		if notify != nil {
+			notify()
+		}
+		return 0
+	}
+	r, _, _ := procDefWindowProcW.Call(hwnd, msg, wParam, lParam)
+	return r
+}
+
+func registerClass() error {
+	classOnce.Do(func() {
+		var inst windows.Handle
+		if classErr = windows.GetModuleHandleEx(0, nil, &inst); classErr != nil {
+			return
+		}
+		className, _ = windows.UTF16PtrFromString("XConnectClipboardListener")
+		wc := wndClassEx{wndProc: windows.NewCallback(wndProc), instance: inst, className: className}
+		wc.size = uint32(unsafe.Sizeof(wc))
+		if r, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&wc))); r == 0 {
+			classErr = fmt.Errorf("RegisterClassExW: %w", err)
+		}
+	})
+	return classErr
+}
+
+// watchFormatListener registers for WM_CLIPBOARDUPDATE on a message-only window.
+// The window and its message loop live on one locked OS thread, as Win32 requires.
+func watchFormatListener(ctx context.Context, notify func()) (<-chan error, error) {
+	if err := registerClass(); err != nil {
+		return nil, err
+	}
+	started := make(chan error, 1)
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		hwnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(className)), 0, 0, 0, 0, 0, 0, hwndMessage, 0, 0, 0)
+		if hwnd == 0 {
+			started <- fmt.Errorf("CreateWindowExW: %w", err)
+			return
+		}
+		defer procDestroyWindow.Call(hwnd)
+		listenersMu.Lock()
+		listeners[hwnd] = notify
+		listenersMu.Unlock()
+		defer func() {
+			listenersMu.Lock()
+			delete(listeners, hwnd)
+			listenersMu.Unlock()
+		}()
+		if r, _, err := procAddClipboardFormatListener.Call(hwnd); r == 0 {
+			started <- fmt.Errorf("AddClipboardFormatListener: %w", err)
+			return
+		}
+		defer procRemoveClipboardFormatListener.Call(hwnd)
+		started <- nil
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				procPostMessageW.Call(hwnd, wmAppStop, 0, 0)
+			case <-stop:
+			}
+		}()
+		var m winMsg
+		for {
+			r, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+			switch int32(r) {
+			case -1:
+				done <- fmt.Errorf("GetMessageW: %w", err)
+				return
+			case 0:
+				done <- fmt.Errorf("message loop quit")
+				return
+			}
+			switch m.message {
+			case wmAppStop:
+				done <- ctx.Err()
+				return
+			case wmClipboardUpdate: // posted, so it is returned here rather than sent to wndProc
+				notify()
+			default:
+				procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+				procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+			}
+		}
+	}()
+	if err := <-started; err != nil {
+		return nil, err
+	}
+	return done, nil
+}
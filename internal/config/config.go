@@ -0,0 +1,216 @@
+// Package config reads the xconnect configuration file: a JSON object whose keys are
+// the server's flag names, e.g.
+//
+//	{"sync": true, "sync-peers": "tag:xconnect", "filter-deny": ["^pass"], "history-max-age": "24h"}
+//
+// Flags given on the command line override the file.
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// DefaultPath returns a platform-specific default path for the config file.
+func DefaultPath() string {
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("AppData")
+		if dir == "" {
+			dir = filepath.Join(os.Getenv("USERPROFILE"), "AppData", "Roaming")
+		}
+		return filepath.Join(dir, "XConnect", "config.json")
+	default:
+		dir := os.Getenv("XDG_CONFIG_HOME")
+		if dir == "" {
+			dir = filepath.Join(os.Getenv("HOME"), ".config")
+		}
+		return filepath.Join(dir, "xconnect", "config.json")
+	}
+}
+
+// Values are flag values by flag name; a repeatable flag may have several.
+type Values map[string][]string
+
+// Load reads the config file at path. A missing file is reported with an error
+// matching os.ErrNotExist.
+func Load(path string) (Values, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	v := make(Values, len(raw))
+	for key, msg := range raw {
+		vals, err := decode(msg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		if vals != nil {
+			v[key] = vals
+		}
+	}
+	return v, nil
+}
+
+// decode turns a JSON string, number, bool or list of them into flag values; null
+// yields nil (the flag's default).
+func decode(msg json.RawMessage) ([]string, error) {
+	d := json.NewDecoder(bytes.NewReader(msg))
+	d.UseNumber()
+	var x any
+	if err := d.Decode(&x); err != nil {
+		return nil, err
+	}
+	if list, ok := x.([]any); ok {
+		vals := []string{}
+		for _, e := range list {
+			s, err := scalar(e)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, s)
+		}
+		return vals, nil
+	}
+	if x == nil {
+		return nil, nil
+	}
+	s, err := scalar(x)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+func scalar(x any) (string, error) {
+	switch x := x.(type) {
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		if x {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return "", errors.New("want a string, number, bool or a list of them")
+}
+
+// ListValue is a repeatable flag. Reset clears it, so that the file's values replace
+// rather than extend the previous ones.
+type ListValue interface {
+	flag.Value
+	Reset()
+}
+
+// Apply sets the flags in fs from next, except those in explicit (given on the
+// command line). Flags that prev set and next no longer does return to their
+// defaults. Unknown keys are rejected before anything is set; if setting a value
+// fails, the flags may be partly updated and Apply(fs, next, prev, explicit)
+// restores them.
+func Apply(fs *flag.FlagSet, prev, next Values, explicit map[string]bool) error {
+	for _, key := range sortedKeys(next) {
+		f := fs.Lookup(key)
+		switch {
+		case key == "config":
+			return errors.New("config: cannot be set in the config file")
+		case f == nil:
+			return fmt.Errorf("unknown setting %q (want a flag name, see xconnect -h)", key)
+		}
+		if _, ok := f.Value.(ListValue); !ok && len(next[key]) != 1 {
+			return fmt.Errorf("%s: want a single value", key)
+		}
+	}
+	for _, key := range sortedKeys(prev) {
+		if _, ok := next[key]; ok || explicit[key] {
+			continue
+		}
+		f := fs.Lookup(key)
+		if f == nil {
+			continue
+		}
+		if l, ok := f.Value.(ListValue); ok {
+			l.Reset()
+		} else if err := f.Value.Set(f.DefValue); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	for _, key := range sortedKeys(next) {
+		if explicit[key] {
+			continue
+		}
+		f := fs.Lookup(key)
+		if l, ok := f.Value.(ListValue); ok {
+			l.Reset()
+		}
+		for _, s := range next[key] {
+			if err := f.Value.Set(s); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(v Values) []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Watch polls the file at path every interval and sends on the returned channel
+// when it was created, changed or removed, until ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	c := make(chan struct{}, 1)
+	last := stat(path)
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if cur := stat(path); !cur.mod.Equal(last.mod) || cur.size != last.size {
+				last = cur
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return c
+}
+
+// fileState identifies a version of a file; the zero value is a missing file.
+type fileState struct {
+	mod  time.Time
+	size int64
+}
+
+func stat(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{info.ModTime(), info.Size()}
+}
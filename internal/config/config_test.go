@@ -0,0 +1,137 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// list is a repeatable flag, like the server's -filter-deny.
+type list []string
+
+func (l *list) String() string     { return strings.Join(*l, ",") }
+func (l *list) Set(s string) error { *l = append(*l, s); return nil }
+func (l *list) Reset()             { *l = nil }
+
+type flags struct {
+	fs     *flag.FlagSet
+	sync   *bool
+	peers  *string
+	maxAge *time.Duration
+	deny   *list
+}
+
+func newFlags() *flags {
+	f := &flags{fs: flag.NewFlagSet("xconnect", flag.ContinueOnError), deny: &list{}}
+	f.sync = f.fs.Bool("sync", false, "")
+	f.peers = f.fs.String("sync-peers", "all", "")
+	f.maxAge = f.fs.Duration("history-max-age", 0, "")
+	f.fs.Var(f.deny, "filter-deny", "")
+	f.fs.String("config", "", "")
+	return f
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"sync": true, "port": 1840, "sync-peers": "tag:x", "filter-deny": ["^a", "b"], "log-file": null}`), 0600)
+	v, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Values{"sync": {"true"}, "port": {"1840"}, "sync-peers": {"tag:x"}, "filter-deny": {"^a", "b"}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Load = %v, want %v", v, want)
+	}
+
+	os.WriteFile(path, []byte(`{"sync": {"on": true}}`), 0600)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "sync: want a string") {
+		t.Errorf("Load of an object value: err = %v", err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load of a missing file: err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	f := newFlags()
+	if err := f.fs.Parse([]string{"-sync-peers", "laptop"}); err != nil {
+		t.Fatal(err)
+	}
+	explicit := map[string]bool{"sync-peers": true}
+
+	v1 := Values{"sync": {"true"}, "sync-peers": {"tag:x"}, "history-max-age": {"24h"}, "filter-deny": {"^a", "b"}}
+	if err := Apply(f.fs, nil, v1, explicit); err != nil {
+		t.Fatal(err)
+	}
+	if !*f.sync || *f.peers != "laptop" || *f.maxAge != 24*time.Hour || !reflect.DeepEqual(*f.deny, list{"^a", "b"}) {
+		t.Errorf("after Apply: sync=%v peers=%q max-age=%v deny=%q; want the flag to win over the file",
+			*f.sync, *f.peers, *f.maxAge, *f.deny)
+	}
+
+	// A reload replaces lists and returns removed settings to their defaults.
+	v2 := Values{"sync-peers": {"all"}, "filter-deny": {"c"}}
+	if err := Apply(f.fs, v1, v2, explicit); err != nil {
+		t.Fatal(err)
+	}
+	if *f.sync || *f.peers != "laptop" || *f.maxAge != 0 || !reflect.DeepEqual(*f.deny, list{"c"}) {
+		t.Errorf("after reload: sync=%v peers=%q max-age=%v deny=%q", *f.sync, *f.peers, *f.maxAge, *f.deny)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	for _, tt := range []struct {
+		v   Values
+		err string
+	}{
+		{Values{"config": {"x.json"}}, "cannot be set in the config file"},
+		{Values{"sync": {"true"}, "colour": {"red"}}, `unknown setting "colour"`},
+		{Values{"sync-peers": {"a", "b"}}, "sync-peers: want a single value"},
+	} {
+		f := newFlags()
+		if err := Apply(f.fs, nil, tt.v, nil); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Apply(%v): err = %v, want %q", tt.v, err, tt.err)
+		}
+		if *f.sync {
+			t.Errorf("Apply(%v) set flags before rejecting the file", tt.v)
+		}
+	}
+}
+
+// TestApplyRollback applies a file whose value does not parse, then restores the
+// previous one, as the server does on a failed reload.
+func TestApplyRollback(t *testing.T) {
+	f := newFlags()
+	prev := Values{"sync": {"true"}, "history-max-age": {"1h"}, "filter-deny": {"^a"}}
+	if err := Apply(f.fs, nil, prev, nil); err != nil {
+		t.Fatal(err)
+	}
+	bad := Values{"filter-deny": {"^b"}, "history-max-age": {"soon"}, "sync-peers": {"tag:x"}}
+	if err := Apply(f.fs, prev, bad, nil); err == nil || !strings.HasPrefix(err.Error(), "history-max-age: ") {
+		t.Fatalf("Apply of a bad duration: err = %v", err)
+	}
+	if err := Apply(f.fs, bad, prev, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !*f.sync || *f.maxAge != time.Hour || *f.peers != "all" || !reflect.DeepEqual(*f.deny, list{"^a"}) {
+		t.Errorf("after rollback: sync=%v peers=%q max-age=%v deny=%q", *f.sync, *f.peers, *f.maxAge, *f.deny)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := Watch(ctx, path, 10*time.Millisecond)
+	os.WriteFile(path, []byte(`{}`), 0600)
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Error("Watch did not report a created file")
+	}
+}
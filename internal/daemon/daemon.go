@@ -1,8 +1,7 @@
 package daemon
 
 import (
-	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,9 +15,11 @@ func IsDaemonChild() bool {
 	return os.Getenv("XCONNECT_DAEMON") == "1"
 }
 
-// RunInBackground starts this program again in the background with stdout/stderr redirected to logPath,
-// and exits the current process. The child will have XCONNECT_DAEMON=1 set so it does not fork again.
-// logPath is used for the child's stdout/stderr; if empty, DefaultLogPath() is used.
+// RunInBackground starts this program again in the background and exits the current process.
+// The child will have XCONNECT_DAEMON=1 set so it does not fork again. It logs to and rotates
+// logPath itself (see internal/logging; if empty, DefaultLogPath() is used). Output outside
+// the logger, such as a panic, goes to CrashLogPath(logPath): the child holds no descriptor
+// of logPath, which on Windows would stop it from being renamed.
 func RunInBackground(logPath string) error {
 	if logPath == "" {
 		logPath = DefaultLogPath()
@@ -27,11 +28,12 @@ func RunInBackground(logPath string) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	crashPath := CrashLogPath(logPath)
+	crashFile, err := os.OpenFile(crashPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return err
 	}
-	defer logFile.Close()
+	defer crashFile.Close()
 
 	self, err := os.Executable()
 	if err != nil {
@@ -43,8 +45,8 @@ func RunInBackground(logPath string) error {
 	cmd := exec.Command(self, args...)
 	cmd.Env = env
 	cmd.Stdin = nil
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
+	cmd.Stdout = crashFile
+	cmd.Stderr = crashFile
 
 	switch runtime.GOOS {
 	case "windows":
@@ -56,19 +58,9 @@ func RunInBackground(logPath string) error {
 	if err := cmd.Start(); err != nil {
 		return err
 	}
+	pid := cmd.Process.Pid // Release resets it
 	_ = cmd.Process.Release()
-	log.Printf("xconnect daemon started (PID %d), logs: %s", cmd.Process.Pid, logPath)
+	slog.Info("xconnect daemon started", "pid", pid, "log", logPath, "crash_log", crashPath)
 	os.Exit(0)
 	return nil
 }
-
-// RedirectLogToFile sets log output to the given file (and optionally stderr).
-// Call this in the daemon child so all log output goes to the file.
-func RedirectLogToFile(w io.Writer, alsoStderr bool) {
-	if alsoStderr {
-		log.SetOutput(io.MultiWriter(os.Stderr, w))
-	} else {
-		log.SetOutput(w)
-	}
-	log.SetFlags(log.Ldate | log.Ltime)
-}
@@ -1,11 +1,10 @@
 package daemon
 
 import (
-	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 // DefaultLogPath returns a platform-specific default path for the log file.
@@ -26,25 +25,9 @@ func DefaultLogPath() string {
 	}
 }
 
-// SetupLog opens the log file (creating parent dirs), sets log output to it and optionally stderr.
-// If logPath is empty, uses DefaultLogPath(). Returns the opened file (caller may defer f.Close()).
-func SetupLog(logPath string, alsoStderr bool) (*os.File, error) {
-	if logPath == "" {
-		logPath = DefaultLogPath()
-	}
-	dir := filepath.Dir(logPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, err
-	}
-	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, err
-	}
-	var w io.Writer = f
-	if alsoStderr {
-		w = io.MultiWriter(os.Stderr, f)
-	}
-	log.SetOutput(w)
-	log.SetFlags(log.Ldate | log.Ltime)
-	return f, nil
+// CrashLogPath returns where the daemon child's stdout and stderr go for logPath:
+// xconnect.crash.log next to xconnect.log. Rotation never touches it.
+func CrashLogPath(logPath string) string {
+	ext := filepath.Ext(logPath)
+	return strings.TrimSuffix(logPath, ext) + ".crash" + ext
 }
@@ -4,10 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
+
+	"tailscale.com/client/tailscale"
 )
 
 // Device represents a peer on the tailnet.
@@ -15,47 +19,88 @@ type Device struct {
 	HostName string   `json:"hostname,omitempty"`
 	IP       string   `json:"ip,omitempty"`
 	Addrs    []string `json:"addrs,omitempty"`
+	Tags     []string `json:"tags,omitempty"` // ACL tags, e.g. "tag:xconnect"
+	User     string   `json:"user,omitempty"` // owner login name; empty for tagged devices
+	Port     string   `json:"port,omitempty"` // service port when known (mDNS SRV); else the caller's default
+	Source   string   `json:"source,omitempty"`
+	Offline  bool     `json:"offline,omitempty"` // reported offline by the source; false when unknown
 }
 
+// Device sources.
+const (
+	SourceTailscale = "tailscale" // tailscale status --json / LocalAPI
+	SourceAPI       = "api"       // Tailscale (or compatible) API
+	SourceHeadscale = "headscale" // Headscale API
+	SourceStatic    = "static"    // -peers list
+	SourceFile      = "file"      // hosts file
+	SourceMDNS      = "mdns"      // LAN mDNS/DNS-SD
+)
+
 // Devices returns the list of tailnet devices. It tries tailscale status --json first
 // (when Tailscale CLI is installed), then Tailscale API if apiToken is set.
 func Devices(ctx context.Context, apiToken string) ([]Device, error) {
-	if out, err := tailscaleStatus(ctx); err == nil {
-		return parseStatusJSON(out)
+	return AllDevices(ctx, DefaultProvider(apiToken))
+}
+
+// AllDevices returns the devices known to p, with the self device (when known) first.
+func AllDevices(ctx context.Context, p Provider) ([]Device, error) {
+	self, peers, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if apiToken != "" {
-		return tailscaleAPI(ctx, apiToken)
+	var list []Device
+	if self != "" {
+		list = append(list, Device{HostName: self})
 	}
-	return nil, fmt.Errorf("device discovery: run 'tailscale status --json' or set TAILSCALE_API_TOKEN")
+	return append(list, peers...), nil
 }
 
 // SelfAndPeers returns the self device hostname and the list of peer devices (excluding self).
 // Uses tailscale status --json when available; selfHost is empty when using API (caller can pass -hostname).
 func SelfAndPeers(ctx context.Context, apiToken string) (selfHost string, peers []Device, err error) {
-	out, err := tailscaleStatus(ctx)
-	if err != nil {
-		if apiToken != "" {
-			all, err := tailscaleAPI(ctx, apiToken)
-			if err != nil {
-				return "", nil, err
-			}
-			return "", all, nil
+	return DefaultProvider(apiToken).Discover(ctx)
+}
+
+// DefaultProvider is the historical discovery chain: tailscale status, then the
+// Tailscale API when apiToken is set.
+func DefaultProvider(apiToken string) Provider {
+	if apiToken == "" {
+		return &TailscaleStatus{}
+	}
+	return Chain(&TailscaleStatus{}, &TailscaleAPI{Token: apiToken})
+}
+
+// TailscaleStatus discovers peers from the local tailscaled: through LocalClient (LocalAPI)
+// when set, e.g. the tsnet server's, otherwise by running `tailscale status --json`.
+type TailscaleStatus struct {
+	LocalClient *tailscale.LocalClient
+}
+
+func (t *TailscaleStatus) Name() string { return SourceTailscale }
+
+func (t *TailscaleStatus) Discover(ctx context.Context) (string, []Device, error) {
+	var out []byte
+	var err error
+	if t.LocalClient != nil {
+		var st any
+		if st, err = t.LocalClient.Status(ctx); err == nil {
+			out, err = json.Marshal(st)
 		}
-		return "", nil, err
+	} else {
+		out, err = tailscaleStatus(ctx)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("tailscale status: %w", err)
 	}
 	var s statusJSON
 	if err := json.Unmarshal(out, &s); err != nil {
 		return "", nil, err
 	}
-	selfHost = s.Self.HostName
+	var peers []Device
 	for _, p := range s.Peer {
-		d := Device{HostName: p.HostName, Addrs: p.TailscaleIPs}
-		if len(p.TailscaleIPs) > 0 {
-			d.IP = p.TailscaleIPs[0]
-		}
-		peers = append(peers, d)
+		peers = append(peers, s.device(p))
 	}
-	return selfHost, peers, nil
+	return s.Self.HostName, peers, nil
 }
 
 func tailscaleStatus(ctx context.Context) ([]byte, error) {
@@ -69,91 +114,152 @@ func tailscaleStatus(ctx context.Context) ([]byte, error) {
 
 // statusJSON matches the structure of tailscale status --json (Peer and Self).
 type statusJSON struct {
-	Self struct {
-		HostName string `json:"HostName"`
-	} `json:"Self"`
-	Peer map[string]struct {
-		HostName string   `json:"HostName"`
-		TailscaleIPs []string `json:"TailscaleIPs"`
-	} `json:"Peer"`
+	Self statusPeer            `json:"Self"`
+	Peer map[string]statusPeer `json:"Peer"`
+	User map[string]struct {
+		LoginName string `json:"LoginName"`
+	} `json:"User"`
 }
 
-func parseStatusJSON(data []byte) ([]Device, error) {
-	var s statusJSON
-	if err := json.Unmarshal(data, &s); err != nil {
-		return nil, err
-	}
-	var list []Device
-	// add self
-	if s.Self.HostName != "" {
-		list = append(list, Device{HostName: s.Self.HostName})
+type statusPeer struct {
+	HostName     string   `json:"HostName"`
+	TailscaleIPs []string `json:"TailscaleIPs"`
+	Tags         []string `json:"Tags"`
+	UserID       int64    `json:"UserID"`
+	Online       bool     `json:"Online"`
+}
+
+func (s *statusJSON) device(p statusPeer) Device {
+	d := Device{HostName: p.HostName, Addrs: p.TailscaleIPs, Tags: p.Tags, Source: SourceTailscale, Offline: !p.Online}
+	if len(p.TailscaleIPs) > 0 {
+		d.IP = p.TailscaleIPs[0]
 	}
-	for _, p := range s.Peer {
-		d := Device{HostName: p.HostName, Addrs: p.TailscaleIPs}
-		if len(p.TailscaleIPs) > 0 {
-			d.IP = p.TailscaleIPs[0]
-		}
-		list = append(list, d)
+	if len(p.Tags) == 0 {
+		d.User = s.User[strconv.FormatInt(p.UserID, 10)].LoginName
 	}
-	return list, nil
+	return d
 }
 
 // Tailscale API: list devices (requires API token from admin console).
 // See https://tailscale.com/kb/1101/api
-const apiBase = "https://api.tailscale.com/api/v2"
+const DefaultAPIBase = "https://api.tailscale.com/api/v2"
+
+// TailscaleAPI discovers devices through the Tailscale control API, or any server
+// implementing GET {BaseURL}/tailnet/{tailnet}/devices. It authenticates with
+// TokenSource when set (e.g. OAuthClient), otherwise with the static Token.
+type TailscaleAPI struct {
+	BaseURL     string // default DefaultAPIBase
+	Tailnet     string // default "-" (the token's tailnet)
+	Token       string
+	TokenSource TokenSource
+	HTTPClient  *http.Client // default: 15s timeout
+}
+
+func (t *TailscaleAPI) Name() string { return SourceAPI }
 
 type apiDevicesResponse struct {
 	Devices []struct {
 		Name      string   `json:"name"`
 		Addresses []string `json:"addresses"`
+		Tags      []string `json:"tags"`
+		User      string   `json:"user"`
+		Connected *bool    `json:"connectedToControl"`
 	} `json:"devices"`
 }
 
-func tailscaleAPI(ctx context.Context, token string) ([]Device, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", apiBase+"/tailnet/-/devices", nil)
-	if err != nil {
-		return nil, err
+// Discover lists the tailnet's devices. The API does not say which device is us, so
+// selfHost is always empty (caller can pass -hostname).
+func (t *TailscaleAPI) Discover(ctx context.Context) (string, []Device, error) {
+	base := strings.TrimSuffix(t.BaseURL, "/")
+	if base == "" {
+		base = DefaultAPIBase
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
+	tailnet := t.Tailnet
+	if tailnet == "" {
+		tailnet = "-"
+	}
+	ts := t.TokenSource
+	if ts == nil {
+		ts = staticToken(t.Token)
+	}
+	resp, err := t.get(ctx, base+"/tailnet/"+tailnet+"/devices", ts)
 	if err != nil {
-		return nil, err
+		return "", nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && t.TokenSource != nil {
+		// The access token may have been revoked or expired early; fetch a new one once.
+		resp.Body.Close()
+		ts.Invalidate()
+		if resp, err = t.get(ctx, base+"/tailnet/"+tailnet+"/devices", ts); err != nil {
+			return "", nil, err
+		}
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("tailscale API: %s", resp.Status)
+		return "", nil, fmt.Errorf("tailscale API: %s", resp.Status)
 	}
 	var out apiDevicesResponse
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	list := make([]Device, 0, len(out.Devices))
 	for _, d := range out.Devices {
-		ip := ""
-		if len(d.Addresses) > 0 {
-			ip = strings.TrimPrefix(d.Addresses[0], "fd7a:115c:a1e0::1/128")
-			if ip == d.Addresses[0] {
-				ip = d.Addresses[0]
-			}
-			// 100.x.x.x format
-			for _, a := range d.Addresses {
-				if strings.HasPrefix(a, "100.") {
-					ip = strings.Split(a, "/")[0]
-					break
-				}
-			}
+		dev := Device{HostName: d.Name, IP: firstIPv4(d.Addresses), Addrs: d.Addresses, Tags: d.Tags, Source: SourceAPI}
+		dev.Offline = d.Connected != nil && !*d.Connected
+		if len(d.Tags) == 0 {
+			dev.User = d.User
 		}
-		list = append(list, Device{HostName: d.Name, IP: ip, Addrs: d.Addresses})
+		list = append(list, dev)
 	}
-	return list, nil
+	return "", list, nil
+}
+
+func (t *TailscaleAPI) get(ctx context.Context, url string, ts TokenSource) (*http.Response, error) {
+	token, err := ts.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return httpClient(t.HTTPClient).Do(req)
+}
+
+// firstIPv4 returns the first 100.x.x.x address (prefix length stripped), else the first address.
+func firstIPv4(addrs []string) string {
+	for _, a := range addrs {
+		if strings.HasPrefix(a, "100.") {
+			return strings.Split(a, "/")[0]
+		}
+	}
+	if len(addrs) > 0 {
+		return strings.Split(addrs[0], "/")[0]
+	}
+	return ""
+}
+
+func httpClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: 15 * time.Second}
 }
 
 // Format base URL for a device (hostname or IP + port).
+// LAN (mDNS) devices and hosts-file entries with an address are addressed by IP,
+// since their hostnames are not resolvable without MagicDNS.
 func BaseURL(d Device, port string) string {
+	if d.Port != "" {
+		port = d.Port
+	}
 	if port == "" {
 		port = "8315"
 	}
+	if (d.Source == SourceMDNS || d.Source == SourceFile) && d.IP != "" {
+		return "http://" + net.JoinHostPort(d.IP, port)
+	}
 	if d.HostName != "" {
 		return "http://" + d.HostName + ":" + port
 	}
@@ -0,0 +1,50 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTailscaleAPIDiscover(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/tailnet/example.com/devices" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tskey-api-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"devices": [
+			{"name": "laptop.example.ts.net", "addresses": ["fd7a:115c:a1e0::1", "100.64.0.1"], "user": "me@example.com", "connectedToControl": true},
+			{"name": "server.example.ts.net", "addresses": ["100.64.0.2"], "tags": ["tag:xconnect"], "user": "me@example.com", "connectedToControl": false},
+			{"name": "old.example.ts.net", "addresses": ["100.64.0.3"]}
+		]}`))
+	}))
+	defer srv.Close()
+
+	api := &TailscaleAPI{BaseURL: srv.URL + "/api/v2/", Tailnet: "example.com", Token: "tskey-api-test"}
+	self, list, err := api.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self != "" {
+		t.Errorf("self = %q, want empty", self)
+	}
+	want := []Device{
+		{HostName: "laptop.example.ts.net", IP: "100.64.0.1", Addrs: []string{"fd7a:115c:a1e0::1", "100.64.0.1"}, User: "me@example.com", Source: SourceAPI},
+		{HostName: "server.example.ts.net", IP: "100.64.0.2", Addrs: []string{"100.64.0.2"}, Tags: []string{"tag:xconnect"}, Source: SourceAPI, Offline: true},
+		{HostName: "old.example.ts.net", IP: "100.64.0.3", Addrs: []string{"100.64.0.3"}, Source: SourceAPI},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("devices:\n got %+v\nwant %+v", list, want)
+	}
+
+	api.Token = "wrong"
+	if _, _, err := api.Discover(context.Background()); err == nil {
+		t.Error("Discover with a bad token succeeded")
+	}
+}
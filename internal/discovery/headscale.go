@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Headscale discovers nodes through a Headscale control server's API
+// (GET {BaseURL}/api/v1/node with an API key from `headscale apikeys create`).
+type Headscale struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client // default: 15s timeout
+}
+
+func (h *Headscale) Name() string { return SourceHeadscale }
+
+type headscaleNodesResponse struct {
+	Nodes []struct {
+		Name        string   `json:"name"`
+		GivenName   string   `json:"givenName"`
+		IPAddresses []string `json:"ipAddresses"`
+		ForcedTags  []string `json:"forcedTags"`
+		ValidTags   []string `json:"validTags"`
+		User        struct {
+			Name string `json:"name"`
+		} `json:"user"`
+		Online bool `json:"online"`
+	} `json:"nodes"`
+}
+
+// Discover lists the nodes known to Headscale. Like the Tailscale API, it cannot
+// tell which node is us, so selfHost is always empty.
+func (h *Headscale) Discover(ctx context.Context) (string, []Device, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(h.BaseURL, "/")+"/api/v1/node", nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	resp, err := httpClient(h.HTTPClient).Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("headscale API: %s", resp.Status)
+	}
+	var out headscaleNodesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", nil, err
+	}
+	list := make([]Device, 0, len(out.Nodes))
+	for _, n := range out.Nodes {
+		name := n.GivenName
+		if name == "" {
+			name = n.Name
+		}
+		d := Device{HostName: name, IP: firstIPv4(n.IPAddresses), Addrs: n.IPAddresses, Source: SourceHeadscale, Offline: !n.Online}
+		for _, t := range append(n.ForcedTags, n.ValidTags...) {
+			d.Tags = appendUnique(d.Tags, t)
+		}
+		if len(d.Tags) == 0 {
+			d.User = n.User.Name
+		}
+		list = append(list, d)
+	}
+	return "", list, nil
+}
@@ -0,0 +1,44 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHeadscaleDiscover(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/node" || r.Header.Get("Authorization") != "Bearer hs-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"nodes": [
+			{"name": "laptop-abc", "givenName": "laptop", "ipAddresses": ["100.64.0.1", "fd7a:115c:a1e0::1"], "user": {"name": "me"}, "online": true},
+			{"name": "server", "ipAddresses": ["100.64.0.2"], "forcedTags": ["tag:xconnect"], "validTags": ["tag:xconnect", "tag:lab"], "user": {"name": "me"}}
+		]}`))
+	}))
+	defer srv.Close()
+
+	h := &Headscale{BaseURL: srv.URL + "/", APIKey: "hs-key"}
+	self, list, err := h.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self != "" {
+		t.Errorf("self = %q, want empty", self)
+	}
+	want := []Device{
+		{HostName: "laptop", IP: "100.64.0.1", Addrs: []string{"100.64.0.1", "fd7a:115c:a1e0::1"}, User: "me", Source: SourceHeadscale},
+		{HostName: "server", IP: "100.64.0.2", Addrs: []string{"100.64.0.2"}, Tags: []string{"tag:xconnect", "tag:lab"}, Source: SourceHeadscale, Offline: true},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("devices:\n got %+v\nwant %+v", list, want)
+	}
+
+	h.APIKey = "wrong"
+	if _, _, err := h.Discover(context.Background()); err == nil {
+		t.Error("Discover with a bad key succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// HostsFile reads peers from a text file, re-read on every Discover so edits apply
+// on the next refresh. One peer per line; blank lines and # comments are ignored:
+//
+//	# hostname   [address]    [port=N] [tag:name ...] [user:login]
+//	laptop       100.64.0.2   tag:xconnect
+//	lab-box      10.0.0.7     port=9000 user:me@example.com
+//	self=desktop
+//
+// A "self=<hostname>" line names this machine, so it is skipped when syncing.
+type HostsFile struct {
+	Path string
+}
+
+func (h *HostsFile) Name() string { return SourceFile }
+
+func (h *HostsFile) Discover(ctx context.Context) (string, []Device, error) {
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	var self string
+	var list []Device
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, ok := strings.CutPrefix(fields[0], "self="); ok {
+			self = v
+			continue
+		}
+		d := Device{HostName: fields[0], Source: SourceFile}
+		for _, f := range fields[1:] {
+			switch {
+			case strings.HasPrefix(f, "tag:"):
+				d.Tags = append(d.Tags, f)
+			case strings.HasPrefix(f, "user:"):
+				d.User = strings.TrimPrefix(f, "user:")
+			case strings.HasPrefix(f, "port="):
+				d.Port = strings.TrimPrefix(f, "port=")
+			case net.ParseIP(f) != nil:
+				d.IP = f
+				d.Addrs = append(d.Addrs, f)
+			default:
+				return "", nil, fmt.Errorf("%s:%d: unexpected field %q", h.Path, n, f)
+			}
+		}
+		list = append(list, d)
+	}
+	if err := sc.Err(); err != nil {
+		return "", nil, err
+	}
+	return self, list, nil
+}
@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeHosts(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "peers")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHostsFileDiscover(t *testing.T) {
+	path := writeHosts(t, `
+# hostname   address      options
+self=desktop
+laptop       100.64.0.2   tag:xconnect   # trailing comment
+lab-box      10.0.0.7     port=9000 user:me@example.com
+
+bare
+`)
+	self, list, err := (&HostsFile{Path: path}).Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self != "desktop" {
+		t.Errorf("self = %q, want desktop", self)
+	}
+	want := []Device{
+		{HostName: "laptop", IP: "100.64.0.2", Addrs: []string{"100.64.0.2"}, Tags: []string{"tag:xconnect"}, Source: SourceFile},
+		{HostName: "lab-box", IP: "10.0.0.7", Addrs: []string{"10.0.0.7"}, User: "me@example.com", Port: "9000", Source: SourceFile},
+		{HostName: "bare", Source: SourceFile},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("devices:\n got %+v\nwant %+v", list, want)
+	}
+}
+
+func TestHostsFileErrors(t *testing.T) {
+	path := writeHosts(t, "laptop 100.64.0.2\nserver what\n")
+	_, _, err := (&HostsFile{Path: path}).Discover(context.Background())
+	if err == nil || !strings.Contains(err.Error(), ":2: unexpected field \"what\"") {
+		t.Errorf("err = %v, want line 2 unexpected field", err)
+	}
+	if _, _, err := (&HostsFile{Path: path + ".missing"}).Discover(context.Background()); !os.IsNotExist(err) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"context"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
+)
+
+// WatchNetMap returns a Manager Watch func that subscribes to the tailscaled IPN bus
+// and reports every netmap change. lc may be nil to use the local system tailscaled.
+func WatchNetMap(lc *tailscale.LocalClient) func(ctx context.Context, changed func()) error {
+	if lc == nil {
+		lc = &tailscale.LocalClient{}
+	}
+	return func(ctx context.Context, changed func()) error {
+		w, err := lc.WatchIPNBus(ctx, ipn.NotifyInitialNetMap|ipn.NotifyNoPrivateKeys)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		for {
+			n, err := w.Next()
+			if err != nil {
+				return err
+			}
+			if n.NetMap != nil {
+				changed()
+			}
+		}
+	}
+}
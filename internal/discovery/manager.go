@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/metrics"
+)
+
+var (
+	lookupDuration  = metrics.NewHistogram("xconnect_discovery_duration_seconds", "Duration of peer discovery lookups by provider.", nil, "provider")
+	lookupErrors    = metrics.NewCounter("xconnect_discovery_errors_total", "Failed peer discovery lookups by provider.", "provider")
+	discoveredPeers = metrics.NewGauge("xconnect_discovery_peers", "Peers found by the last successful lookup.")
+)
+
+// Snapshot is an immutable view of the tailnet as seen by the last successful lookup.
+type Snapshot struct {
+	SelfHost  string
+	Peers     []Device
+	UpdatedAt time.Time
+}
+
+// ManagerOptions configures a Manager.
+type ManagerOptions struct {
+	// Provider returns the self hostname and peers. Defaults to DefaultProvider(APIToken).
+	Provider Provider
+	APIToken string
+	// Interval between periodic refreshes (default 30s).
+	Interval time.Duration
+	// Timeout for a single lookup (default 5s).
+	Timeout time.Duration
+	// Watch, if set, blocks until ctx is done and calls changed whenever the tailnet
+	// changes (e.g. WatchNetMap). Errors are logged and the watch is restarted.
+	Watch func(ctx context.Context, changed func()) error
+	// OnUpdate, if set, is called after every successful refresh with the new snapshot.
+	OnUpdate func(*Snapshot)
+}
+
+// Manager refreshes the peer set in the background and serves it from an
+// atomically swapped snapshot, so readers never wait on a lookup.
+type Manager struct {
+	opts ManagerOptions
+	snap atomic.Pointer[Snapshot]
+	kick chan struct{}
+
+	mu        sync.Mutex
+	lastErr   error
+	lastErrAt time.Time
+}
+
+// NewManager returns a Manager; call Run to start refreshing.
+func NewManager(opts ManagerOptions) *Manager {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Provider == nil {
+		opts.Provider = DefaultProvider(opts.APIToken)
+	}
+	m := &Manager{opts: opts, kick: make(chan struct{}, 1)}
+	m.snap.Store(&Snapshot{})
+	return m
+}
+
+// Snapshot returns the latest snapshot. It never blocks and is never nil.
+func (m *Manager) Snapshot() *Snapshot {
+	return m.snap.Load()
+}
+
+// Peers returns the peers from the latest snapshot.
+func (m *Manager) Peers() []Device {
+	return m.snap.Load().Peers
+}
+
+// SelfHost returns the self hostname from the latest snapshot (empty if unknown).
+func (m *Manager) SelfHost() string {
+	return m.snap.Load().SelfHost
+}
+
+// LastError returns when the last lookup failed and why; err is nil if the last
+// lookup succeeded.
+func (m *Manager) LastError() (at time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastErrAt, m.lastErr
+}
+
+// Refresh requests an out-of-band refresh; it does not wait for it to complete.
+func (m *Manager) Refresh() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+}
+
+// RefreshNow performs a lookup synchronously and stores the result.
+func (m *Manager) RefreshNow(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.opts.Timeout)
+	defer cancel()
+	start := time.Now()
+	self, peers, err := m.opts.Provider.Discover(ctx)
+	lookupDuration.Since(start, m.opts.Provider.Name())
+	m.mu.Lock()
+	if err != nil {
+		err = fmt.Errorf("%s: %w", m.opts.Provider.Name(), err)
+		m.lastErr, m.lastErrAt = err, time.Now()
+		lookupErrors.Inc(m.opts.Provider.Name())
+	} else {
+		m.lastErr = nil
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	prev := m.snap.Load()
+	if self == "" {
+		self = prev.SelfHost
+	}
+	snap := &Snapshot{SelfHost: self, Peers: peers, UpdatedAt: time.Now()}
+	discoveredPeers.Set(float64(len(peers)))
+	m.snap.Store(snap)
+	if m.opts.OnUpdate != nil {
+		m.opts.OnUpdate(snap)
+	}
+	return nil
+}
+
+// Run refreshes on the configured interval and on watch notifications until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	if m.opts.Watch != nil {
+		go m.watch(ctx)
+	}
+	tick := time.NewTicker(m.opts.Interval)
+	defer tick.Stop()
+	for {
+		if err := m.RefreshNow(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("discovery: refresh failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		case <-m.kick:
+		}
+	}
+}
+
+func (m *Manager) watch(ctx context.Context) {
+	for {
+		err := m.opts.Watch(ctx, m.Refresh)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("discovery: watch failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.opts.Interval):
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+// MDNSService is the DNS-SD service type advertised by xconnect servers.
+const MDNSService = "_xconnect._tcp"
+
+// DefaultMDNSGroup is the IPv4 mDNS multicast group (RFC 6762).
+var DefaultMDNSGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// MDNSOptions configures AdvertiseMDNS and BrowseMDNS. Zero values use the standard
+// group, service and domain, so only Port (advertise) is normally required.
+type MDNSOptions struct {
+	Service   string         // default MDNSService
+	Domain    string         // default "local."
+	Instance  string         // instance/host label; default os.Hostname() up to the first dot
+	Port      int            // service port to advertise
+	Interface *net.Interface // nil = system default
+	Group     *net.UDPAddr   // default DefaultMDNSGroup; tests may use another group/port
+	// BrowseTimeout bounds how long BrowseMDNS collects answers (default 1s).
+	BrowseTimeout time.Duration
+}
+
+func (o MDNSOptions) withDefaults() MDNSOptions {
+	if o.Service == "" {
+		o.Service = MDNSService
+	}
+	if o.Domain == "" {
+		o.Domain = "local."
+	}
+	o.Domain = dns.Fqdn(o.Domain)
+	if o.Instance == "" {
+		o.Instance = shortHostname()
+	}
+	if o.Group == nil {
+		o.Group = DefaultMDNSGroup
+	}
+	if o.BrowseTimeout <= 0 {
+		o.BrowseTimeout = time.Second
+	}
+	return o
+}
+
+func (o MDNSOptions) serviceName() string  { return o.Service + "." + o.Domain }
+func (o MDNSOptions) instanceName() string { return o.Instance + "." + o.serviceName() }
+func (o MDNSOptions) targetName() string   { return o.Instance + "." + o.Domain }
+
+func shortHostname() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "xconnect"
+	}
+	h, _, _ = strings.Cut(h, ".")
+	return h
+}
+
+// AdvertiseMDNS answers DNS-SD queries for the xconnect service on the LAN until ctx is done.
+// It announces the service once on start so browsers pick it up without waiting.
+func AdvertiseMDNS(ctx context.Context, opts MDNSOptions) error {
+	opts = opts.withDefaults()
+	if opts.Port <= 0 {
+		return errors.New("mdns: advertise: port is required")
+	}
+	conn, err := net.ListenMulticastUDP("udp4", opts.Interface, opts.Group)
+	if err != nil {
+		return fmt.Errorf("mdns: listen: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	pc := ipv4.NewPacketConn(conn)
+	_ = pc.SetMulticastLoopback(true)
+	if opts.Interface != nil {
+		_ = pc.SetMulticastInterface(opts.Interface)
+	}
+
+	if b, err := opts.answer(0, true).Pack(); err == nil {
+		conn.WriteToUDP(b, opts.Group)
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("mdns: read: %w", err)
+		}
+		var q dns.Msg
+		if err := q.Unpack(buf[:n]); err != nil || q.Response || !opts.asked(&q) {
+			continue
+		}
+		// Queries from a port other than 5353 are "legacy unicast" (RFC 6762 §6.7):
+		// answer directly to the sender, echoing the query ID and question.
+		dst := opts.Group
+		id := uint16(0)
+		if src.Port != opts.Group.Port {
+			dst, id = src, q.Id
+		}
+		resp := opts.answer(id, false)
+		if id != 0 {
+			resp.Question = q.Question
+		}
+		b, err := resp.Pack()
+		if err != nil {
+			slog.Warn("mdns: pack", "err", err)
+			continue
+		}
+		if _, err := conn.WriteToUDP(b, dst); err != nil && ctx.Err() == nil {
+			slog.Debug("mdns: reply failed", "to", dst, "err", err)
+		}
+	}
+}
+
+// asked reports whether q asks for our service or instance.
+func (o MDNSOptions) asked(q *dns.Msg) bool {
+	for _, qq := range q.Question {
+		name := strings.ToLower(qq.Name)
+		switch {
+		case name == strings.ToLower(o.serviceName()) && (qq.Qtype == dns.TypePTR || qq.Qtype == dns.TypeANY):
+			return true
+		case name == strings.ToLower(o.instanceName()) || name == strings.ToLower(o.targetName()):
+			return true
+		}
+	}
+	return false
+}
+
+// answer builds the PTR/SRV/TXT/A record set for this instance.
+func (o MDNSOptions) answer(id uint16, announce bool) *dns.Msg {
+	const ttl = 120
+	m := new(dns.Msg)
+	m.Id = id
+	m.Response = true
+	m.Authoritative = true
+	hdr := func(name string, t uint16) dns.RR_Header {
+		h := dns.RR_Header{Name: name, Rrtype: t, Class: dns.ClassINET, Ttl: ttl}
+		if announce && t != dns.TypePTR {
+			h.Class |= 1 << 15 // cache-flush bit for unique records
+		}
+		return h
+	}
+	m.Answer = append(m.Answer, &dns.PTR{Hdr: hdr(o.serviceName(), dns.TypePTR), Ptr: o.instanceName()})
+	m.Extra = append(m.Extra,
+		&dns.SRV{Hdr: hdr(o.instanceName(), dns.TypeSRV), Target: o.targetName(), Port: uint16(o.Port)},
+		&dns.TXT{Hdr: hdr(o.instanceName(), dns.TypeTXT), Txt: []string{"path=/"}},
+	)
+	for _, ip := range o.localIPs() {
+		m.Extra = append(m.Extra, &dns.A{Hdr: hdr(o.targetName(), dns.TypeA), A: ip})
+	}
+	return m
+}
+
+// localIPs returns the IPv4 addresses to advertise: those of Interface when set, otherwise
+// every up, non-loopback interface (or loopback when there is nothing else).
+func (o MDNSOptions) localIPs() []net.IP {
+	var ifaces []net.Interface
+	if o.Interface != nil {
+		ifaces = []net.Interface{*o.Interface}
+	} else {
+		ifaces, _ = net.Interfaces()
+	}
+	var ips, loopback []net.IP
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok || ipn.IP.To4() == nil {
+				continue
+			}
+			if ipn.IP.IsLoopback() {
+				loopback = append(loopback, ipn.IP.To4())
+			} else {
+				ips = append(ips, ipn.IP.To4())
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return loopback
+	}
+	return ips
+}
+
+// BrowseMDNS queries the LAN for xconnect services and returns one Device per instance
+// that answered within opts.BrowseTimeout (or before ctx is done). Our own instance
+// (opts.Instance) is included; callers exclude it like any other self entry.
+func BrowseMDNS(ctx context.Context, opts MDNSOptions) ([]Device, error) {
+	opts = opts.withDefaults()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("mdns: browse: %w", err)
+	}
+	defer conn.Close()
+	if opts.Interface != nil {
+		_ = ipv4.NewPacketConn(conn).SetMulticastInterface(opts.Interface)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion(opts.serviceName(), dns.TypePTR)
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(b, opts.Group); err != nil {
+		return nil, fmt.Errorf("mdns: query: %w", err)
+	}
+
+	deadline := time.Now().Add(opts.BrowseTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	instances := map[string]*Device{} // instance FQDN -> device
+	targets := map[string]string{}    // instance FQDN -> SRV target
+	addrs := map[string][]string{}    // target -> IPv4s
+	var order []string
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("mdns: read: %w", err)
+		}
+		var m dns.Msg
+		if err := m.Unpack(buf[:n]); err != nil || !m.Response {
+			continue
+		}
+		for _, rr := range append(m.Answer, m.Extra...) {
+			name := strings.ToLower(rr.Header().Name)
+			switch r := rr.(type) {
+			case *dns.PTR:
+				svc := opts.serviceName()
+				if name != strings.ToLower(svc) || len(r.Ptr) <= len(svc)+1 {
+					continue
+				}
+				inst := strings.ToLower(r.Ptr)
+				d := instances[inst]
+				if d == nil {
+					d = &Device{Source: SourceMDNS}
+					instances[inst] = d
+				}
+				if d.HostName == "" {
+					d.HostName = r.Ptr[:len(r.Ptr)-len(svc)-1]
+					order = append(order, inst)
+				}
+			case *dns.SRV:
+				// SRV can arrive before the PTR that names the instance.
+				d := instances[name]
+				if d == nil {
+					d = &Device{Source: SourceMDNS}
+					instances[name] = d
+				}
+				d.Port = strconv.Itoa(int(r.Port))
+				targets[name] = strings.ToLower(r.Target)
+			case *dns.A:
+				addrs[name] = appendUnique(addrs[name], r.A.String())
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	var list []Device
+	for _, inst := range order {
+		d := instances[inst]
+		d.Addrs = addrs[targets[inst]]
+		if len(d.Addrs) > 0 {
+			d.IP = d.Addrs[0]
+		}
+		list = append(list, *d)
+	}
+	return list, nil
+}
+
+// MDNS is a Provider that browses the LAN; the self host is Options.Instance.
+type MDNS struct {
+	Options MDNSOptions
+}
+
+func (m *MDNS) Name() string { return SourceMDNS }
+
+func (m *MDNS) Discover(ctx context.Context) (string, []Device, error) {
+	opts := m.Options.withDefaults()
+	all, err := BrowseMDNS(ctx, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	var peers []Device
+	for _, d := range all {
+		if !strings.EqualFold(d.HostName, opts.Instance) {
+			peers = append(peers, d)
+		}
+	}
+	return opts.Instance, peers, nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
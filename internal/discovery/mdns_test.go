@@ -0,0 +1,75 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// loopback returns the loopback interface, skipping the test when it cannot carry
+// multicast (enable it with "ip link set lo multicast on").
+func loopback(t *testing.T) *net.Interface {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for i := range ifaces {
+		ifi := &ifaces[i]
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			if ifi.Flags&net.FlagMulticast == 0 {
+				t.Skipf("%s: multicast disabled", ifi.Name)
+			}
+			return ifi
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+func TestMDNSLoopback(t *testing.T) {
+	lo := loopback(t)
+	// A non-standard port keeps the test off a real mDNS responder on 5353.
+	opts := MDNSOptions{
+		Instance:      "xc-test",
+		Port:          48123,
+		Interface:     lo,
+		Group:         &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 45353},
+		BrowseTimeout: 500 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- AdvertiseMDNS(ctx, opts) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("AdvertiseMDNS: %v", err)
+		}
+	}()
+
+	var found []Device
+	for i := 0; i < 5 && len(found) == 0; i++ {
+		list, err := BrowseMDNS(ctx, opts)
+		if err != nil {
+			t.Fatalf("BrowseMDNS: %v", err)
+		}
+		found = list
+	}
+	if len(found) != 1 {
+		t.Fatalf("found %d devices, want 1: %+v", len(found), found)
+	}
+	d := found[0]
+	if d.HostName != "xc-test" || d.IP != "127.0.0.1" || d.Port != "48123" || d.Source != SourceMDNS {
+		t.Errorf("found %+v, want xc-test at 127.0.0.1:48123", d)
+	}
+
+	// The provider drops its own instance.
+	self, peers, err := (&MDNS{Options: opts}).Discover(ctx)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if self != "xc-test" || len(peers) != 0 {
+		t.Errorf("Discover = %q, %+v; want xc-test and no peers", self, peers)
+	}
+}
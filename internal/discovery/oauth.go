@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies bearer tokens for API requests.
+type TokenSource interface {
+	// Token returns a valid access token, fetching a new one when needed.
+	Token(ctx context.Context) (string, error)
+	// Invalidate drops the cached token, e.g. after the API answered 401.
+	Invalidate()
+}
+
+// OAuthClient is a TokenSource using the OAuth 2.0 client-credentials grant, as issued
+// by the Tailscale admin console (Settings → OAuth clients). Unlike API access tokens,
+// client credentials do not expire; access tokens are fetched as needed and refreshed
+// shortly before they expire.
+type OAuthClient struct {
+	TokenURL     string // default DefaultAPIBase + "/oauth/token"
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // optional; Tailscale grants the client's configured scopes when empty
+	HTTPClient   *http.Client
+	// RefreshBefore is how long before expiry a token is replaced (default 1m).
+	RefreshBefore time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (o *OAuthClient) Token(ctx context.Context) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	refreshBefore := o.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = time.Minute
+	}
+	if o.token != "" && (o.expires.IsZero() || time.Until(o.expires) > refreshBefore) {
+		return o.token, nil
+	}
+	tok, exp, err := o.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	o.token, o.expires = tok, exp
+	return tok, nil
+}
+
+func (o *OAuthClient) Invalidate() {
+	o.mu.Lock()
+	o.token = ""
+	o.mu.Unlock()
+}
+
+func (o *OAuthClient) fetch(ctx context.Context) (string, time.Time, error) {
+	if o.ClientID == "" || o.ClientSecret == "" {
+		return "", time.Time{}, errors.New("oauth: client id and secret are required")
+	}
+	tokenURL := o.TokenURL
+	if tokenURL == "" {
+		tokenURL = DefaultAPIBase + "/oauth/token"
+	}
+	// Credentials go in the form body (client_secret_post), as Tailscale documents.
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {o.ClientID},
+		"client_secret": {o.ClientSecret},
+	}
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	start := time.Now()
+	resp, err := httpClient(o.HTTPClient).Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("oauth: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", time.Time{}, fmt.Errorf("oauth: token endpoint: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out oauthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", time.Time{}, fmt.Errorf("oauth: %w", err)
+	}
+	if out.AccessToken == "" {
+		return "", time.Time{}, errors.New("oauth: token endpoint returned no access_token")
+	}
+	var exp time.Time
+	if out.ExpiresIn > 0 {
+		exp = start.Add(time.Duration(out.ExpiresIn) * time.Second)
+	}
+	return out.AccessToken, exp, nil
+}
+
+// staticToken is a TokenSource for a fixed API access token.
+type staticToken string
+
+func (s staticToken) Token(context.Context) (string, error) { return string(s), nil }
+func (s staticToken) Invalidate()                           {}
@@ -0,0 +1,134 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeOAuth is a client-credentials token endpoint issuing tok-1, tok-2, ...
+type fakeOAuth struct {
+	expiresIn int
+	issued    atomic.Int32
+}
+
+func (f *fakeOAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if r.PostFormValue("grant_type") != "client_credentials" ||
+		r.PostFormValue("client_id") != "id" || r.PostFormValue("client_secret") != "secret" {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	if s := r.PostFormValue("scope"); s != "devices:core:read" {
+		http.Error(w, "scope "+s, http.StatusBadRequest)
+		return
+	}
+	n := f.issued.Add(1)
+	fmt.Fprintf(w, `{"access_token": "tok-%d", "token_type": "Bearer", "expires_in": %d}`, n, f.expiresIn)
+}
+
+func newOAuthClient(t *testing.T, expiresIn int) (*OAuthClient, *fakeOAuth) {
+	f := &fakeOAuth{expiresIn: expiresIn}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return &OAuthClient{TokenURL: srv.URL, ClientID: "id", ClientSecret: "secret", Scopes: []string{"devices:core:read"}}, f
+}
+
+func TestOAuthTokenExchange(t *testing.T) {
+	o, f := newOAuthClient(t, 3600)
+	for i := 0; i < 3; i++ {
+		tok, err := o.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok != "tok-1" {
+			t.Errorf("token %d = %q, want the cached tok-1", i, tok)
+		}
+	}
+	if n := f.issued.Load(); n != 1 {
+		t.Errorf("issued %d tokens, want 1", n)
+	}
+
+	o.Invalidate()
+	if tok, _ := o.Token(context.Background()); tok != "tok-2" {
+		t.Errorf("after Invalidate: token = %q, want tok-2", tok)
+	}
+
+	o.ClientSecret = "wrong"
+	o.Invalidate()
+	if _, err := o.Token(context.Background()); err == nil {
+		t.Error("Token with a bad secret succeeded")
+	}
+}
+
+func TestOAuthRefreshBeforeExpiry(t *testing.T) {
+	// Tokens live 30s, inside the default 1m refresh margin, so each call fetches anew.
+	o, f := newOAuthClient(t, 30)
+	for i := 1; i <= 2; i++ {
+		tok, err := o.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("tok-%d", i); tok != want {
+			t.Errorf("token = %q, want %q", tok, want)
+		}
+	}
+	// With a smaller margin the same token is reused.
+	o.RefreshBefore = 10 * time.Second
+	o.Invalidate()
+	a, _ := o.Token(context.Background())
+	b, _ := o.Token(context.Background())
+	if a != b || f.issued.Load() != 3 {
+		t.Errorf("tokens %q, %q after %d fetches; want one reused token", a, b, f.issued.Load())
+	}
+}
+
+func TestTailscaleAPIRetriesOnce401(t *testing.T) {
+	o, f := newOAuthClient(t, 3600)
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		// The first token was "revoked"; later ones work.
+		if auth == "Bearer tok-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"devices": [{"name": "laptop", "addresses": ["100.64.0.2"]}]}`))
+	}))
+	defer api.Close()
+
+	p := &TailscaleAPI{BaseURL: api.URL, TokenSource: o}
+	_, list, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].HostName != "laptop" {
+		t.Errorf("devices = %+v", list)
+	}
+	if len(seen) != 2 || seen[1] != "Bearer tok-2" {
+		t.Errorf("requests = %q, want tok-1 then tok-2", seen)
+	}
+
+	// A token that is still refused after one refresh fails without looping.
+	seen = nil
+	o.Invalidate()
+	f.issued.Store(0)
+	api.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	if _, _, err := p.Discover(context.Background()); err == nil {
+		t.Error("Discover succeeded against an API that always answers 401")
+	}
+	if len(seen) != 2 {
+		t.Errorf("made %d requests, want 2", len(seen))
+	}
+}
@@ -0,0 +1,276 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"tailscale.com/client/tailscale"
+)
+
+// Provider is a source of peer devices.
+type Provider interface {
+	// Name identifies the provider in logs and errors.
+	Name() string
+	// Discover returns the self hostname (empty if the provider cannot tell) and the peers.
+	Discover(ctx context.Context) (selfHost string, peers []Device, err error)
+}
+
+// Chain returns a Provider that uses the first of ps that succeeds.
+func Chain(ps ...Provider) Provider {
+	return &chain{ps: ps}
+}
+
+type chain struct{ ps []Provider }
+
+func (c *chain) Name() string { return joinNames(c.ps, "|") }
+
+func (c *chain) Discover(ctx context.Context) (string, []Device, error) {
+	var errs []error
+	for _, p := range c.ps {
+		self, peers, err := p.Discover(ctx)
+		if err == nil {
+			return self, peers, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+	if len(errs) == 0 {
+		return "", nil, errors.New("device discovery: no providers")
+	}
+	return "", nil, errors.Join(errs...)
+}
+
+// Merge returns a Provider that queries every p and merges the results. Devices are
+// deduplicated by short hostname (or IP when there is none); the first provider to
+// report a device wins its address, port and source, while tags and addresses are
+// unioned and an empty owner is filled in from later providers. Self is the first
+// non-empty self hostname. Merge fails only when every provider fails.
+func Merge(ps ...Provider) Provider {
+	if len(ps) == 1 {
+		return ps[0]
+	}
+	return &merge{ps: ps}
+}
+
+type merge struct{ ps []Provider }
+
+func (m *merge) Name() string { return joinNames(m.ps, "+") }
+
+func (m *merge) Discover(ctx context.Context) (string, []Device, error) {
+	type result struct {
+		self  string
+		peers []Device
+		err   error
+	}
+	results := make([]result, len(m.ps))
+	done := make(chan struct{})
+	for i, p := range m.ps {
+		go func(i int, p Provider) {
+			self, peers, err := p.Discover(ctx)
+			results[i] = result{self, peers, err}
+			done <- struct{}{}
+		}(i, p)
+	}
+	for range m.ps {
+		<-done
+	}
+
+	var self string
+	var list []Device
+	index := map[string]int{}
+	var errs []error
+	for i, r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.ps[i].Name(), r.err))
+			continue
+		}
+		if self == "" {
+			self = r.self
+		}
+		for _, d := range r.peers {
+			k := deviceKey(d)
+			j, ok := index[k]
+			if !ok || k == "" {
+				index[k] = len(list)
+				list = append(list, d)
+				continue
+			}
+			list[j] = mergeDevice(list[j], d)
+		}
+	}
+	if len(errs) == len(m.ps) {
+		return "", nil, errors.Join(errs...)
+	}
+	return self, list, nil
+}
+
+// deviceKey is the dedup key: the lowercase short hostname, else the IP.
+func deviceKey(d Device) string {
+	if d.HostName != "" {
+		h, _, _ := strings.Cut(strings.ToLower(d.HostName), ".")
+		if net.ParseIP(d.HostName) == nil {
+			return h
+		}
+		return d.HostName
+	}
+	return d.IP
+}
+
+func mergeDevice(a, b Device) Device {
+	if a.IP == "" {
+		a.IP = b.IP
+	}
+	if a.Port == "" {
+		a.Port = b.Port
+	}
+	if a.User == "" && len(a.Tags) == 0 {
+		a.User = b.User
+	}
+	for _, t := range b.Tags {
+		a.Tags = appendUnique(a.Tags, t)
+	}
+	for _, ad := range b.Addrs {
+		a.Addrs = appendUnique(a.Addrs, ad)
+	}
+	a.Offline = a.Offline && b.Offline // online if any source can see it
+	return a
+}
+
+func joinNames(ps []Provider, sep string) string {
+	names := make([]string, len(ps))
+	for i, p := range ps {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, sep)
+}
+
+// Static is a fixed list of peer hostnames or IPs (the -peers flag).
+type Static []string
+
+func (s Static) Name() string { return SourceStatic }
+
+func (s Static) Discover(ctx context.Context) (string, []Device, error) {
+	var list []Device
+	for _, h := range s {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		d := Device{HostName: h, Source: SourceStatic}
+		if net.ParseIP(h) != nil {
+			d.IP = h
+		}
+		list = append(list, d)
+	}
+	return "", list, nil
+}
+
+// Config selects and configures providers for NewProvider.
+type Config struct {
+	// Providers is a comma-separated list of provider names, merged in order:
+	//   tailscale  tailscale status / LocalAPI, falling back to the API when credentials are set
+	//   api        Tailscale API only (APIBase, Tailnet, APIToken or OAuth*)
+	//   headscale  Headscale API (HeadscaleURL, HeadscaleKey)
+	//   file       hosts file (HostsFile)
+	//   mdns       LAN mDNS/DNS-SD (MDNS)
+	// Empty means "tailscale".
+	Providers string
+	APIToken  string
+	APIBase   string
+	Tailnet   string
+	// OAuth client credentials for the Tailscale API; used instead of APIToken when set.
+	// OAuthTokenURL defaults to APIBase + "/oauth/token".
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthTokenURL     string
+	OAuthScopes       []string
+	HeadscaleURL      string
+	HeadscaleKey      string
+	HostsFile         string
+	MDNS              MDNSOptions
+	// LocalClient is used by the tailscale provider instead of the CLI when set (e.g. tsnet).
+	LocalClient *tailscale.LocalClient
+	// Static, when non-empty, overrides Providers with a fixed peer list.
+	Static []string
+}
+
+// NewProvider builds the provider described by c.
+func NewProvider(c Config) (Provider, error) {
+	if len(c.Static) > 0 {
+		return Static(c.Static), nil
+	}
+	names := c.Providers
+	if strings.TrimSpace(names) == "" {
+		names = SourceTailscale
+	}
+	var api *TailscaleAPI
+	if c.OAuthClientID != "" || c.OAuthClientSecret != "" {
+		if c.OAuthClientID == "" || c.OAuthClientSecret == "" {
+			return nil, errors.New("discovery: OAuth needs both client id and client secret")
+		}
+		tokenURL := c.OAuthTokenURL
+		if tokenURL == "" {
+			base := strings.TrimSuffix(c.APIBase, "/")
+			if base == "" {
+				base = DefaultAPIBase
+			}
+			tokenURL = base + "/oauth/token"
+		}
+		api = &TailscaleAPI{BaseURL: c.APIBase, Tailnet: c.Tailnet, TokenSource: &OAuthClient{
+			TokenURL:     tokenURL,
+			ClientID:     c.OAuthClientID,
+			ClientSecret: c.OAuthClientSecret,
+			Scopes:       c.OAuthScopes,
+		}}
+	} else if c.APIToken != "" {
+		api = &TailscaleAPI{BaseURL: c.APIBase, Tailnet: c.Tailnet, Token: c.APIToken}
+	}
+	var ps []Provider
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "":
+			continue
+		case SourceTailscale:
+			var p Provider = &TailscaleStatus{LocalClient: c.LocalClient}
+			if api != nil {
+				p = Chain(p, api)
+			}
+			ps = append(ps, p)
+		case SourceAPI:
+			if api == nil {
+				return nil, errors.New("discovery api: set -api-token (TAILSCALE_API_TOKEN) or -oauth-client-id and -oauth-client-secret")
+			}
+			ps = append(ps, api)
+		case SourceHeadscale:
+			if c.HeadscaleURL == "" || c.HeadscaleKey == "" {
+				return nil, errors.New("discovery headscale: set -headscale-url and -headscale-key (or HEADSCALE_API_KEY)")
+			}
+			ps = append(ps, &Headscale{BaseURL: c.HeadscaleURL, APIKey: c.HeadscaleKey})
+		case SourceFile:
+			if c.HostsFile == "" {
+				return nil, errors.New("discovery file: set -peers-file")
+			}
+			ps = append(ps, &HostsFile{Path: c.HostsFile})
+		case SourceMDNS:
+			ps = append(ps, &MDNS{Options: c.MDNS})
+		default:
+			return nil, fmt.Errorf("discovery: unknown provider %q (want tailscale, api, headscale, file or mdns)", name)
+		}
+	}
+	if len(ps) == 0 {
+		return nil, errors.New("discovery: no providers")
+	}
+	return Merge(ps...), nil
+}
+
+// HasProvider reports whether the comma-separated provider list names includes name.
+func HasProvider(names, name string) bool {
+	for _, n := range strings.Split(names, ",") {
+		if strings.EqualFold(strings.TrimSpace(n), name) {
+			return true
+		}
+	}
+	return false
+}
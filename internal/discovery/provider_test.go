@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeProvider returns a fixed result.
+type fakeProvider struct {
+	name  string
+	self  string
+	peers []Device
+	err   error
+	calls int
+}
+
+func (f *fakeProvider) Name() string { return f.name }
+
+func (f *fakeProvider) Discover(context.Context) (string, []Device, error) {
+	f.calls++
+	return f.self, f.peers, f.err
+}
+
+func TestChain(t *testing.T) {
+	failing := &fakeProvider{name: "a", err: errors.New("down")}
+	ok := &fakeProvider{name: "b", self: "me", peers: []Device{{HostName: "peer"}}}
+	unused := &fakeProvider{name: "c", self: "other"}
+	c := Chain(failing, ok, unused)
+	if c.Name() != "a|b|c" {
+		t.Errorf("Name = %q", c.Name())
+	}
+	self, peers, err := c.Discover(context.Background())
+	if err != nil || self != "me" || len(peers) != 1 {
+		t.Errorf("Discover = %q, %+v, %v", self, peers, err)
+	}
+	if unused.calls != 0 {
+		t.Error("Chain queried a provider after one succeeded")
+	}
+
+	ok.err = errors.New("also down")
+	unused.err = errors.New("gone")
+	_, _, err = c.Discover(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "a: down") || !strings.Contains(err.Error(), "b: also down") {
+		t.Errorf("err = %v, want both provider errors", err)
+	}
+}
+
+func TestMergeDedup(t *testing.T) {
+	ts := &fakeProvider{name: "tailscale", self: "desktop", peers: []Device{
+		{HostName: "laptop.example.ts.net", IP: "100.64.0.2", Addrs: []string{"100.64.0.2"}, User: "me", Source: SourceTailscale, Offline: true},
+		{HostName: "server", IP: "100.64.0.3", Tags: []string{"tag:xconnect"}, Source: SourceTailscale},
+	}}
+	file := &fakeProvider{name: "file", self: "ignored", peers: []Device{
+		// Same short hostname, different case: merged into the first entry.
+		{HostName: "LAPTOP", IP: "10.0.0.2", Addrs: []string{"10.0.0.2"}, Port: "9000", Tags: []string{"tag:lab"}, Source: SourceFile},
+		// IP-only entries are keyed by IP.
+		{HostName: "10.0.0.9", IP: "10.0.0.9", Source: SourceFile},
+		{HostName: "10.0.0.9", IP: "10.0.0.9", User: "later", Source: SourceFile},
+	}}
+	mdns := &fakeProvider{name: "mdns", peers: []Device{
+		{HostName: "server", IP: "192.168.1.3", Port: "8315", User: "someone", Source: SourceMDNS},
+		{HostName: "printer", IP: "192.168.1.9", Source: SourceMDNS},
+	}}
+	failing := &fakeProvider{name: "api", err: errors.New("down")}
+
+	m := Merge(ts, file, mdns, failing)
+	if m.Name() != "tailscale+file+mdns+api" {
+		t.Errorf("Name = %q", m.Name())
+	}
+	self, list, err := m.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self != "desktop" {
+		t.Errorf("self = %q, want the first provider's", self)
+	}
+	want := []Device{
+		// First provider wins the address and source; tags and addresses are unioned;
+		// online if any source sees it; the owner is kept once tags are present.
+		{HostName: "laptop.example.ts.net", IP: "100.64.0.2", Addrs: []string{"100.64.0.2", "10.0.0.2"}, User: "me", Port: "9000", Tags: []string{"tag:lab"}, Source: SourceTailscale},
+		// Tagged devices do not pick up an owner from later providers.
+		{HostName: "server", IP: "100.64.0.3", Port: "8315", Tags: []string{"tag:xconnect"}, Source: SourceTailscale},
+		{HostName: "10.0.0.9", IP: "10.0.0.9", User: "later", Source: SourceFile},
+		{HostName: "printer", IP: "192.168.1.9", Source: SourceMDNS},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("merged:\n got %+v\nwant %+v", list, want)
+	}
+
+	for _, p := range []*fakeProvider{ts, file, mdns} {
+		p.err = errors.New("down")
+	}
+	if _, _, err := m.Discover(context.Background()); err == nil {
+		t.Error("Merge succeeded with every provider failing")
+	}
+	if Merge(ts) != Provider(ts) {
+		t.Error("Merge of one provider should return it unchanged")
+	}
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Selector picks peers by ACL tag, owner or hostname. A device matches when any term matches.
+//
+// Terms are comma-separated:
+//
+//	tag:xconnect          device carries ACL tag "tag:xconnect"
+//	user:me@example.com   device is owned by this login (glob allowed, e.g. user:*@example.com)
+//	host:laptop-*         hostname glob; a bare term without prefix is treated as host:
+type Selector struct {
+	terms []selectorTerm
+}
+
+type selectorTerm struct {
+	kind    string // "tag", "user" or "host"
+	pattern string
+}
+
+// ParseSelector parses a comma-separated selector expression. An empty string yields a
+// selector that matches every device.
+func ParseSelector(expr string) (*Selector, error) {
+	sel := &Selector{}
+	for _, t := range strings.Split(expr, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		kind, pattern, ok := strings.Cut(t, ":")
+		if !ok {
+			kind, pattern = "host", t
+		}
+		kind = strings.ToLower(kind)
+		switch kind {
+		case "tag":
+			// Tags are stored with their "tag:" prefix.
+			pattern = "tag:" + pattern
+		case "user", "host":
+		default:
+			return nil, fmt.Errorf("selector %q: unknown kind %q (want tag:, user: or host:)", t, kind)
+		}
+		if pattern == "" || strings.HasSuffix(pattern, ":") {
+			return nil, fmt.Errorf("selector %q: empty pattern", t)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("selector %q: %v", t, err)
+		}
+		sel.terms = append(sel.terms, selectorTerm{kind: kind, pattern: pattern})
+	}
+	return sel, nil
+}
+
+// Empty reports whether the selector has no terms (and so matches everything).
+func (s *Selector) Empty() bool {
+	return s == nil || len(s.terms) == 0
+}
+
+// Match reports whether d is selected.
+func (s *Selector) Match(d Device) bool {
+	if s.Empty() {
+		return true
+	}
+	for _, t := range s.terms {
+		switch t.kind {
+		case "tag":
+			for _, tag := range d.Tags {
+				if globMatch(t.pattern, tag) {
+					return true
+				}
+			}
+		case "user":
+			if d.User != "" && globMatch(t.pattern, d.User) {
+				return true
+			}
+		case "host":
+			if globMatch(t.pattern, d.HostName) {
+				return true
+			}
+			// API hostnames are FQDNs (laptop.tailnet.ts.net); also match the short name.
+			if short, _, ok := strings.Cut(d.HostName, "."); ok && globMatch(t.pattern, short) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter returns the devices in list that match s.
+func (s *Selector) Filter(list []Device) []Device {
+	if s.Empty() {
+		return list
+	}
+	var out []Device
+	for _, d := range list {
+		if s.Match(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(s.terms))
+	for _, t := range s.terms {
+		if t.kind == "tag" {
+			parts = append(parts, t.pattern)
+		} else {
+			parts = append(parts, t.kind+":"+t.pattern)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func globMatch(pattern, name string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
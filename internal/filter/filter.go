@@ -0,0 +1,162 @@
+// Package filter decides which clipboard content may leave or enter this device
+// through auto-sync: deny-list patterns, built-in secret detectors, a size cap and
+// the copying app's "concealed" hint.
+package filter
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Directions, used in counters and log lines.
+const (
+	Outgoing = "outgoing"
+	Incoming = "incoming"
+)
+
+// Detector recognizes one secret format.
+type Detector struct {
+	Name string
+	Re   *regexp.Regexp
+}
+
+// Secrets are the built-in detectors.
+var Secrets = []Detector{
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws.{0,20}secret.{0,20}[=:"' ]\s*[A-Za-z0-9/+]{40}\b`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{4,}\.eyJ[A-Za-z0-9_-]{4,}\.[A-Za-z0-9_-]{8,}`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{50,})\b`)},
+}
+
+// Options configures a Filter. The zero value lets everything through.
+type Options struct {
+	Deny    []*regexp.Regexp // content matching any pattern is filtered
+	Secrets bool             // apply the built-in Secrets detectors
+	MaxSize int              // bytes; 0 = no limit
+	// Concealed, if set, reports whether the local clipboard is marked as a password
+	// by the copying app (clipboard.Concealed). Only checked for outgoing content.
+	Concealed func() bool
+}
+
+// Filter applies Options and counts what it filters, by direction and reason.
+type Filter struct {
+	mu     sync.Mutex
+	opts   Options
+	counts map[string]uint64 // "direction/reason" -> events
+}
+
+// New returns a Filter for opts.
+func New(opts Options) *Filter {
+	return &Filter{opts: opts, counts: make(map[string]uint64)}
+}
+
+// SetOptions replaces the rules, e.g. on config reload; the counts are kept.
+func (f *Filter) SetOptions(opts Options) {
+	f.mu.Lock()
+	f.opts = opts
+	f.mu.Unlock()
+}
+
+func (f *Filter) options() Options {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.opts
+}
+
+// ParseDeny compiles deny-list patterns.
+func ParseDeny(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("deny pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Check returns why content must not be synced in direction, or "" if it may be.
+// Reasons are "size", "concealed", "secret:<detector>" and "deny:<n>" (1-based pattern index).
+func (f *Filter) Check(direction, content string) string {
+	if f == nil {
+		return ""
+	}
+	opts := f.options()
+	if opts.MaxSize > 0 && len(content) > opts.MaxSize {
+		return "size"
+	}
+	if direction == Outgoing && opts.Concealed != nil && opts.Concealed() {
+		return "concealed"
+	}
+	if opts.Secrets {
+		for _, d := range Secrets {
+			if d.Re.MatchString(content) {
+				return "secret:" + d.Name
+			}
+		}
+	}
+	for i, re := range opts.Deny {
+		if re.MatchString(content) {
+			return fmt.Sprintf("deny:%d", i+1)
+		}
+	}
+	return ""
+}
+
+// Allow reports whether content may be synced in direction. Filtered content is
+// counted and logged with its reason and size only; peer names the sender or is empty.
+func (f *Filter) Allow(direction, peer, content string) bool {
+	reason := f.Check(direction, content)
+	if reason == "" {
+		return true
+	}
+	f.mu.Lock()
+	f.counts[direction+"/"+reason]++
+	n := f.counts[direction+"/"+reason]
+	f.mu.Unlock()
+	attrs := []any{"direction", direction}
+	if peer != "" {
+		attrs = append(attrs, "peer", peer)
+	}
+	attrs = append(attrs, "reason", reason, "bytes", len(content), "blocked_total", n)
+	slog.Info("filter: blocked clipboard", attrs...)
+	return false
+}
+
+// Counts returns the number of filtered events keyed by "direction/reason".
+func (f *Filter) Counts() map[string]uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]uint64, len(f.counts))
+	for k, v := range f.counts {
+		out[k] = v
+	}
+	return out
+}
+
+// String describes the active rules, for the startup log.
+func (f *Filter) String() string {
+	opts := f.options()
+	var rules []string
+	if opts.Secrets {
+		rules = append(rules, "secrets")
+	}
+	if opts.Concealed != nil {
+		rules = append(rules, "concealed")
+	}
+	if opts.MaxSize > 0 {
+		rules = append(rules, fmt.Sprintf("max-size=%d", opts.MaxSize))
+	}
+	if len(opts.Deny) > 0 {
+		rules = append(rules, fmt.Sprintf("deny=%d", len(opts.Deny)))
+	}
+	if len(rules) == 0 {
+		return "none"
+	}
+	return strings.Join(rules, ", ")
+}
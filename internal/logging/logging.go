@@ -0,0 +1,133 @@
+// Package logging sets up log/slog for the xconnect daemon: a level, text or JSON
+// output, redaction of clipboard and message payloads, and rotation of the log file.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Options configures Setup.
+type Options struct {
+	Level  string // debug, info (default), warn or error
+	Format string // text (default) or json
+	// File is the log file; empty = stderr only. Stderr also copies to stderr.
+	File   string
+	Stderr bool
+	// File rotation (see RotatingFile): by size, by age, and how many rotated files
+	// to keep. Zero disables each.
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+// level is the level of the logger installed by Setup; see SetLevel.
+var level slog.LevelVar
+
+// Setup installs a logger built from opts as the slog default, which also routes the
+// standard log package through it. Close the returned io.Closer on exit.
+func Setup(opts Options) (io.Closer, error) {
+	l, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	level.Set(l)
+	var w io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+	if opts.File != "" {
+		f, err := OpenRotating(opts.File, opts.MaxSize, opts.MaxAge, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		// Logged from another goroutine: the handler is mid-write when this is called.
+		f.OnError = func(err error) {
+			go slog.Error("logging: cannot rotate the log file; still appending to it", "err", err)
+		}
+		w, closer = f, f
+		if opts.Stderr {
+			w = io.MultiWriter(os.Stderr, f)
+		}
+	}
+	h, err := NewHandler(w, &level, opts.Format)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+	slog.SetDefault(slog.New(h))
+	return closer, nil
+}
+
+// SetLevel changes the level of the logger installed by Setup while running.
+func SetLevel(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// ParseLevel parses debug, info, warn or error (case-insensitive; empty = info).
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("log level %q: want debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// NewHandler returns a text or JSON handler writing to w at level that redacts payloads.
+func NewHandler(w io.Writer, level slog.Leveler, format string) (slog.Handler, error) {
+	ho := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	switch format {
+	case "", "text":
+		return slog.NewTextHandler(w, ho), nil
+	case "json":
+		return slog.NewJSONHandler(w, ho), nil
+	}
+	return nil, fmt.Errorf("log format %q: want text or json", format)
+}
+
+// redactedKeys are attribute keys that may carry clipboard content or message text.
+// Their values are never written, whatever the caller passes. Only these keys are
+// redacted: content inside other attributes, such as an error's text, is written
+// as is, so errors must not be built from payloads.
+var redactedKeys = map[string]bool{
+	"content":   true,
+	"clipboard": true,
+	"text":      true,
+	"message":   true,
+	"body":      true,
+	"payload":   true,
+}
+
+// redact replaces the values of redactedKeys with their size.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && (a.Key == slog.MessageKey || a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+		return a
+	}
+	if !redactedKeys[strings.ToLower(a.Key)] {
+		return a
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, fmt.Sprintf("[redacted %d bytes]", len(v.String())))
+	case slog.KindAny:
+		if b, ok := v.Any().([]byte); ok {
+			return slog.String(a.Key, fmt.Sprintf("[redacted %d bytes]", len(b)))
+		}
+	}
+	return slog.String(a.Key, "[redacted]")
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,193 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTime is the timestamp added to rotated files: xconnect-20240102T150405.log.
+const backupTime = "20060102T150405"
+
+// rotateRetry is how long a file that could not be renamed is appended to before
+// rotation is tried again.
+const rotateRetry = time.Minute
+
+// RotatingFile appends to a log file and rotates it when it would grow beyond
+// MaxSize bytes or was started more than MaxAge ago, keeping MaxBackups rotated
+// files next to it. Zero disables each limit.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	// OnError, if set, is told when the file cannot be renamed (e.g. on Windows while
+	// another process has it open); it keeps growing until a retry succeeds. Called
+	// once per run of failures, with the file locked: it must not write to r.
+	OnError func(error)
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	started time.Time
+	retryAt time.Time // after a failed rename
+	failing bool
+}
+
+// OpenRotating opens (creating parent dirs) or appends to the log file at path.
+// An existing file older than maxAge is rotated first.
+func OpenRotating(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge, MaxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if r.expired() {
+		if err := r.rotate(); err != nil {
+			r.f.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// open opens Path for appending; r.mu must be held (or r not yet shared).
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.started = f, info.Size(), time.Now()
+	if r.size > 0 {
+		r.started = info.ModTime() // at the latest; the file's creation time is not portable
+	}
+	return nil
+}
+
+func (r *RotatingFile) expired() bool {
+	return r.MaxAge > 0 && r.size > 0 && time.Since(r.started) > r.MaxAge
+}
+
+// Write appends p, rotating first if p would exceed MaxSize or the file is too old.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	full := r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize
+	if (full || r.expired()) && !time.Now().Before(r.retryAt) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file with a timestamp, opens a new one and removes old backups;
+// r.mu must be held.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.Path)
+	stem := strings.TrimSuffix(r.Path, ext) + "-" + time.Now().Format(backupTime)
+	backup := stem + ext
+	for i := 1; ; i++ { // several rotations within a second
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%d%s", stem, i, ext)
+	}
+	if err := os.Rename(r.Path, backup); err != nil && !os.IsNotExist(err) {
+		// Keep logging to the old file rather than losing output, and say so.
+		if oerr := r.open(); oerr != nil {
+			r.f = nil
+			return oerr
+		}
+		r.retryAt = time.Now().Add(rotateRetry)
+		if !r.failing && r.OnError != nil {
+			r.OnError(fmt.Errorf("rotate %s: %w", r.Path, err))
+		}
+		r.failing = true
+		return nil
+	}
+	if err := r.open(); err != nil {
+		r.f = nil
+		return err
+	}
+	r.started, r.retryAt, r.failing = time.Now(), time.Time{}, false
+	r.prune()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond MaxBackups.
+func (r *RotatingFile) prune() {
+	if r.MaxBackups <= 0 {
+		return
+	}
+	ext := filepath.Ext(r.Path)
+	prefix := strings.TrimSuffix(filepath.Base(r.Path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(r.Path))
+	if err != nil {
+		return
+	}
+	type backup struct {
+		name, stamp string
+		n           int // .N suffix of several rotations within a second
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp, n := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), 0
+		if i := strings.IndexByte(stamp, '.'); i >= 0 {
+			var err error
+			if n, err = strconv.Atoi(stamp[i+1:]); err != nil {
+				continue
+			}
+			stamp = stamp[:i]
+		}
+		if _, err := time.Parse(backupTime, stamp); err == nil {
+			backups = append(backups, backup{name, stamp, n})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { // oldest first
+		if backups[i].stamp != backups[j].stamp {
+			return backups[i].stamp < backups[j].stamp
+		}
+		return backups[i].n < backups[j].n
+	})
+	for len(backups) > r.MaxBackups {
+		os.Remove(filepath.Join(filepath.Dir(r.Path), backups[0].name))
+		backups = backups[1:]
+	}
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
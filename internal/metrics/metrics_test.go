@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// output writes ms as a registry of their own, so other tests' metrics on Default
+// do not show up.
+func output(ms ...metric) string {
+	r := &Registry{}
+	for _, m := range ms {
+		r.register(m)
+	}
+	var b strings.Builder
+	r.Write(&b)
+	return b.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests served.", "route", "code")
+	c.Inc("/b", "200")
+	c.Add(2, "/a", "404")
+	c.Inc("/a", "404")
+	plain := NewCounter("test_plain_total", "No labels.")
+	g := NewGauge("test_peers", "Peers found.")
+	g.Set(2.5)
+
+	want := `# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",code="404"} 3
+test_requests_total{route="/b",code="200"} 1
+# HELP test_plain_total No labels.
+# TYPE test_plain_total counter
+test_plain_total 0
+# HELP test_peers Peers found.
+# TYPE test_peers gauge
+test_peers 2.5
+`
+	if got := output(c, plain, g); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounter("test_escape_total", "Escaping.", "peer")
+	c.Inc("a\"b\\c\nd")
+	want := `test_escape_total{peer="a\"b\\c\nd"} 1` + "\n"
+	if got := output(c); !strings.HasSuffix(got, want) {
+		t.Errorf("output:\n%s\nwant suffix:\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Latency.", []float64{0.1, 1, 10}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 5, 60} {
+		h.Observe(v, "/x")
+	}
+	want := `# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/x",le="0.1"} 2
+test_duration_seconds_bucket{route="/x",le="1"} 3
+test_duration_seconds_bucket{route="/x",le="10"} 4
+test_duration_seconds_bucket{route="/x",le="+Inf"} 5
+test_duration_seconds_sum{route="/x"} 65.65
+test_duration_seconds_count{route="/x"} 5
+`
+	if got := output(h); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for v, want := range map[float64]string{1: "1", 0.25: "0.25", 1e21: "1e+21", math.Inf(1): "+Inf", math.Inf(-1): "-Inf"} {
+		if got := formatFloat(v); got != want {
+			t.Errorf("formatFloat(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewGauge("test_handler_gauge", "Served by Handler.").Set(7)
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "\ntest_handler_gauge 7\n") {
+		t.Errorf("body does not include the gauge:\n%s", rec.Body.String())
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	c := NewCounter("test_labels_total", "Labels.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with one of two label values did not panic")
+		}
+	}()
+	c.Inc("x")
+}
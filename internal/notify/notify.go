@@ -0,0 +1,7 @@
+// Package notify shows desktop notifications where the platform has a command for it.
+package notify
+
+import "errors"
+
+// ErrUnsupported is returned by Send on platforms without a notification command.
+var ErrUnsupported = errors.New("desktop notifications not supported on this platform")
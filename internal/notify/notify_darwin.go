@@ -0,0 +1,15 @@
+//go:build darwin
+
+package notify
+
+import "os/exec"
+
+// Send shows a notification through osascript. Title and body are passed as
+// arguments, not spliced into the script.
+func Send(title, body string) error {
+	return exec.Command("osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, body).Run()
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !freebsd && !netbsd && !openbsd && !darwin
+
+package notify
+
+// Send returns ErrUnsupported; on Windows the tray shows pending items instead.
+func Send(title, body string) error {
+	return ErrUnsupported
+}
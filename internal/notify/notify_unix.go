@@ -0,0 +1,10 @@
+//go:build linux || freebsd || netbsd || openbsd
+
+package notify
+
+import "os/exec"
+
+// Send shows a notification with notify-send (libnotify).
+func Send(title, body string) error {
+	return exec.Command("notify-send", "-a", "XConnect", title, body).Run()
+}
@@ -0,0 +1,221 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/protocol"
+)
+
+// Target is a peer that discovery reports online.
+type Target struct {
+	Names   []string // hostnames and addresses the peer is known by
+	BaseURL string
+}
+
+// DeliverOptions configures Run.
+type DeliverOptions struct {
+	// Targets returns the peers that are currently online.
+	Targets func() []Target
+	// Interval between delivery passes (default 30s); Kick starts one early.
+	Interval time.Duration
+	// HTTPClient sends the items (default http.DefaultClient). Each request is given
+	// requestTimeout, or transferTimeout for files.
+	HTTPClient *http.Client
+	// GetFromHost returns our hostname for the X-From-Host header (optional).
+	GetFromHost func() string
+	// Protocol, if set, handshakes with peers to pick /v1 paths; nil = legacy paths.
+	Protocol *protocol.Cache
+	// CanSend, if set, reports whether clipboard items may be sent now (false while
+	// sync is paused or receive-only); they are held until it does. Messages and files
+	// are sent regardless.
+	CanSend func() bool
+}
+
+// Run delivers pending items to online peers on every Interval and Kick until ctx is done.
+func (o *Outbox) Run(ctx context.Context, opts DeliverOptions) {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	tick := time.NewTicker(opts.Interval)
+	defer tick.Stop()
+	for {
+		o.deliverAll(ctx, &opts)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		case <-o.kick:
+		}
+	}
+}
+
+func (o *Outbox) deliverAll(ctx context.Context, opts *DeliverOptions) {
+	keys, err := o.Peers()
+	if err != nil {
+		slog.Error("outbox: list", "err", err)
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	online := make(map[string]string)
+	for _, t := range opts.Targets() {
+		for _, n := range t.Names {
+			online[Key(strings.Split(n, "/")[0])] = t.BaseURL
+		}
+	}
+	for _, k := range keys {
+		items, err := o.Pending(k)
+		if err != nil {
+			slog.Warn("outbox: delivery failed", "peer", k, "err", err)
+			continue
+		}
+		if len(items) == 0 {
+			os.Remove(o.peerPath(k)) // only succeeds when empty
+			continue
+		}
+		base, ok := online[k]
+		if !ok {
+			continue
+		}
+		sent := 0
+		for _, it := range items {
+			if ctx.Err() != nil {
+				return
+			}
+			if it.Kind == KindClipboard && opts.CanSend != nil && !opts.CanSend() {
+				continue
+			}
+			if err := o.send(ctx, opts, base, it); err != nil {
+				var rejected *rejectedError
+				if errors.As(err, &rejected) {
+					// Retrying will not help; later items must not wait behind it.
+					slog.Warn("outbox: dropped undeliverable item", "peer", it.Peer, "kind", it.Kind, "err", err)
+					o.Remove(it)
+					continue
+				}
+				slog.Warn("outbox: delivery failed", "peer", it.Peer, "err", err, "pending", len(items)-sent)
+				break
+			}
+			o.Remove(it)
+			sent++
+		}
+		if sent > 0 {
+			slog.Info("outbox: delivered", "peer", k, "items", sent)
+		}
+	}
+}
+
+// Per-request deadlines, matching the server's route timeouts.
+const (
+	requestTimeout  = 30 * time.Second
+	transferTimeout = 10 * time.Minute // file items
+)
+
+// rejectedError is a failure that retrying will not fix: a 4xx answer other than
+// 408 and 429, a peer whose protocol is incompatible, or a file item whose data is
+// gone. The item is dropped.
+type rejectedError struct{ error }
+
+func (e *rejectedError) Unwrap() error { return e.error }
+
+func (o *Outbox) send(ctx context.Context, opts *DeliverOptions, base string, it Item) error {
+	base = strings.TrimSuffix(base, "/")
+	hello := protocol.Legacy() // unprefixed paths without a handshake
+	if opts.Protocol != nil {
+		var err error
+		if hello, err = opts.Protocol.Get(ctx, base); protocol.IsIncompatible(err) {
+			return &rejectedError{err}
+		} else if err != nil {
+			return fmt.Errorf("handshake: %w", err)
+		}
+	}
+	timeout := requestTimeout
+	if it.Kind == KindFile {
+		timeout = transferTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var (
+		url  string
+		body io.Reader
+		ct   string
+	)
+	switch it.Kind {
+	case KindClipboard:
+		url, body, ct = base+hello.Path("/clipboard"), strings.NewReader(it.Text), "text/plain; charset=utf-8"
+	case KindMessage:
+		payload, _ := json.Marshal(map[string]string{"text": it.Text})
+		url, body, ct = base+hello.Path("/message"), bytes.NewReader(payload), "application/json"
+	case KindFile:
+		f, err := os.Open(it.DataPath())
+		if errors.Is(err, os.ErrNotExist) {
+			return &rejectedError{err}
+		} else if err != nil {
+			return err
+		}
+		// Stream the form rather than holding the file in memory.
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			defer f.Close()
+			part, err := mw.CreateFormFile("file", it.Name)
+			if err == nil {
+				_, err = io.Copy(part, f)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		url, body, ct = base+hello.Path("/files"), pr, mw.FormDataContentType()
+	default:
+		return &rejectedError{fmt.Errorf("unknown item kind %q", it.Kind)}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ct)
+	protocol.SetHeader(req)
+	for k, v := range it.Header {
+		req.Header.Set(k, v)
+	}
+	if opts.GetFromHost != nil {
+		if from := opts.GetFromHost(); from != "" {
+			req.Header.Set("X-From-Host", from)
+		}
+	}
+	resp, err := opts.HTTPClient.Do(req)
+	if err != nil {
+		opts.Protocol.Forget(base)
+		return fmt.Errorf("POST %s: %w", url, err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusUpgradeRequired:
+		opts.Protocol.Forget(base)
+		return &rejectedError{fmt.Errorf("POST %s: %s (peer needs a newer xconnect)", url, resp.Status)}
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return &rejectedError{fmt.Errorf("POST %s: %s", url, resp.Status)}
+	}
+	return fmt.Errorf("POST %s: %s", url, resp.Status)
+}
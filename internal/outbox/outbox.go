@@ -0,0 +1,267 @@
+// Package outbox keeps clipboard updates, messages and files for peers that are
+// offline in a directory on disk, and delivers them when the peer is back online.
+//
+// Items are stored unencrypted, so the directory is created with mode 0700 and every
+// file with mode 0600: only the user running xconnect can read queued content.
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Item kinds.
+const (
+	KindClipboard = "clipboard" // at most one per peer; a newer one replaces it
+	KindMessage   = "message"
+	KindFile      = "file"
+)
+
+// clipboardFile is the fixed name of a peer's pending clipboard item (latest wins).
+const clipboardFile = "clipboard.json"
+
+// Item is one pending delivery.
+type Item struct {
+	ID     string            `json:"id"`
+	Peer   string            `json:"peer"` // peer as given by the sender
+	Kind   string            `json:"kind"`
+	Text   string            `json:"text,omitempty"`   // clipboard content or message text
+	Name   string            `json:"name,omitempty"`   // original file name (KindFile)
+	Header map[string]string `json:"header,omitempty"` // extra request headers (clipboard version)
+	Queued time.Time         `json:"queued"`
+
+	dir string
+}
+
+// DataPath returns the path of a file item's contents.
+func (it Item) DataPath() string {
+	return filepath.Join(it.dir, it.ID+".data")
+}
+
+// DefaultDir returns a platform-specific default outbox directory, next to the log file.
+func DefaultDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("LocalAppData")
+		if dir == "" {
+			dir = filepath.Join(os.Getenv("USERPROFILE"), "AppData", "Local")
+		}
+		return filepath.Join(dir, "XConnect", "outbox")
+	default:
+		dir := os.Getenv("XDG_STATE_HOME")
+		if dir == "" {
+			dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+		}
+		return filepath.Join(dir, "xconnect", "outbox")
+	}
+}
+
+// Outbox is a directory with one subdirectory per peer and one JSON file per item.
+// Items are written to a temporary file and renamed into place, so the server and
+// the CLI can share an outbox without locking.
+type Outbox struct {
+	Dir    string
+	MaxAge time.Duration // items older than this are dropped; 0 = keep until delivered
+
+	kick chan struct{}
+}
+
+// Open creates dir if needed, restricts it to the current user, and returns an
+// Outbox for it.
+func Open(dir string, maxAge time.Duration) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Outbox{Dir: dir, MaxAge: maxAge, kick: make(chan struct{}, 1)}, nil
+}
+
+// Key returns the directory name for peer: the lowercase short hostname, or the IP
+// address (with ':' replaced, for Windows).
+func Key(peer string) string {
+	peer = strings.ToLower(strings.TrimSpace(peer))
+	if net.ParseIP(peer) != nil {
+		return strings.ReplaceAll(peer, ":", "_")
+	}
+	if i := strings.IndexByte(peer, '.'); i > 0 {
+		peer = peer[:i]
+	}
+	return peer
+}
+
+func (o *Outbox) peerPath(key string) string {
+	return filepath.Join(o.Dir, key)
+}
+
+func (o *Outbox) peerDir(peer string) (string, error) {
+	k := Key(peer)
+	if k == "" || k == "." || k == ".." || strings.ContainsAny(k, `/\`) {
+		return "", fmt.Errorf("outbox: invalid peer %q", peer)
+	}
+	dir := filepath.Join(o.Dir, k)
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// PutClipboard stores content for peer, replacing any clipboard item already pending.
+func (o *Outbox) PutClipboard(peer, content string, header map[string]string) error {
+	return o.put(peer, clipboardFile, Item{Kind: KindClipboard, Text: content, Header: header}, nil)
+}
+
+// AddMessage queues a message for peer.
+func (o *Outbox) AddMessage(peer, text string) error {
+	return o.put(peer, "", Item{Kind: KindMessage, Text: text}, nil)
+}
+
+// AddFile queues a copy of the file at path for peer.
+func (o *Outbox) AddFile(peer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return o.put(peer, "", Item{Kind: KindFile, Name: filepath.Base(path)}, f)
+}
+
+// put writes it (and data, for files) under the peer's directory. name is the item
+// file name; empty means a new time-ordered name.
+func (o *Outbox) put(peer, name string, it Item, data io.Reader) error {
+	dir, err := o.peerDir(peer)
+	if err != nil {
+		return err
+	}
+	it.Peer = peer
+	it.Queued = time.Now().UTC()
+	if name == "" {
+		b := make([]byte, 4)
+		rand.Read(b)
+		it.ID = fmt.Sprintf("%020d-%s", it.Queued.UnixNano(), hex.EncodeToString(b))
+		name = it.ID + ".json"
+	} else {
+		it.ID = strings.TrimSuffix(name, ".json")
+	}
+	if data != nil {
+		if err := writeAtomic(filepath.Join(dir, it.ID+".data"), data); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(filepath.Join(dir, name), strings.NewReader(string(b))); err != nil {
+		return err
+	}
+	o.Kick()
+	return nil
+}
+
+// writeAtomic writes r to path through a temporary file; the file has mode 0600.
+func writeAtomic(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Peers returns the keys of peers with a directory in the outbox.
+func (o *Outbox) Peers() ([]string, error) {
+	entries, err := os.ReadDir(o.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		if e.IsDir() {
+			keys = append(keys, e.Name())
+		}
+	}
+	return keys, nil
+}
+
+// Pending returns the items queued for peer, oldest first. Expired items are removed.
+func (o *Outbox) Pending(peer string) ([]Item, error) {
+	dir := filepath.Join(o.Dir, Key(peer))
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var it Item
+		if err := json.Unmarshal(b, &it); err != nil {
+			slog.Warn("outbox: unreadable item, removing", "file", filepath.Join(dir, name), "err", err)
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		it.dir = dir
+		if o.MaxAge > 0 && time.Since(it.Queued) > o.MaxAge {
+			slog.Info("outbox: dropping expired item", "kind", it.Kind, "peer", it.Peer, "queued_ago", time.Since(it.Queued).Round(time.Minute))
+			o.Remove(it)
+			continue
+		}
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Queued.Before(items[j].Queued) })
+	return items, nil
+}
+
+// Remove deletes a delivered or expired item. A clipboard item that has been
+// replaced by a newer one since it was read is left alone.
+func (o *Outbox) Remove(it Item) error {
+	path := filepath.Join(it.dir, it.ID+".json")
+	switch it.Kind {
+	case KindFile:
+		os.Remove(it.DataPath())
+	case KindClipboard:
+		var cur Item
+		if b, err := os.ReadFile(path); err == nil && json.Unmarshal(b, &cur) == nil && !cur.Queued.Equal(it.Queued) {
+			return nil
+		}
+	}
+	return os.Remove(path)
+}
+
+// Kick requests a delivery pass from Run; it does not wait for it.
+func (o *Outbox) Kick() {
+	select {
+	case o.kick <- struct{}{}:
+	default:
+	}
+}
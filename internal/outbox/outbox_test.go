@@ -0,0 +1,276 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T, maxAge time.Duration) *Outbox {
+	t.Helper()
+	o, err := Open(filepath.Join(t.TempDir(), "outbox"), maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestKey(t *testing.T) {
+	for peer, want := range map[string]string{
+		"Laptop.tail1234.ts.net": "laptop",
+		" phone ":                "phone",
+		"100.64.0.9":             "100.64.0.9",
+		"fd7a:115c::1":           "fd7a_115c__1",
+	} {
+		if got := Key(peer); got != want {
+			t.Errorf("Key(%q) = %q, want %q", peer, got, want)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	o := open(t, 0)
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(src, []byte("file body"), 0644)
+
+	if err := o.PutClipboard("laptop", "first", nil); err != nil {
+		t.Fatal(err)
+	}
+	o.AddMessage("Laptop.local", "hi")
+	o.AddFile("laptop", src)
+	// The newest clipboard replaces the pending one, and sorts by its own time.
+	o.PutClipboard("laptop", "second", map[string]string{"X-Clipboard-Clock": "2"})
+
+	items, err := o.Pending("laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, it := range items {
+		got = append(got, it.Kind+":"+it.Text+it.Name)
+	}
+	if want := []string{"message:hi", "file:notes.txt", "clipboard:second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pending = %q, want %q", got, want)
+	}
+	if h := items[2].Header["X-Clipboard-Clock"]; h != "2" {
+		t.Errorf("clipboard header = %q", h)
+	}
+	if b, err := os.ReadFile(items[1].DataPath()); err != nil || string(b) != "file body" {
+		t.Errorf("file data = %q, %v", b, err)
+	}
+	if info, err := os.Stat(items[1].DataPath()); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file data mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+	if keys, _ := o.Peers(); !reflect.DeepEqual(keys, []string{"laptop"}) {
+		t.Errorf("Peers = %q", keys)
+	}
+	if err := o.AddMessage("..", "x"); err == nil {
+		t.Error(`AddMessage to peer ".." succeeded`)
+	}
+
+	for _, it := range items {
+		if err := o.Remove(it); err != nil {
+			t.Error(err)
+		}
+	}
+	if items, _ := o.Pending("laptop"); len(items) != 0 {
+		t.Errorf("after Remove: Pending = %+v", items)
+	}
+	if _, err := os.Stat(items[1].DataPath()); !os.IsNotExist(err) {
+		t.Errorf("file data left after Remove: %v", err)
+	}
+}
+
+func TestRemoveReplacedClipboard(t *testing.T) {
+	o := open(t, 0)
+	o.PutClipboard("laptop", "old", nil)
+	items, _ := o.Pending("laptop")
+	time.Sleep(time.Millisecond)
+	o.PutClipboard("laptop", "new", nil)
+
+	// Delivering the old content must not remove the newer one queued meanwhile.
+	o.Remove(items[0])
+	items, _ = o.Pending("laptop")
+	if len(items) != 1 || items[0].Text != "new" {
+		t.Errorf("Pending = %+v, want the new clipboard", items)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	o := open(t, 20*time.Millisecond)
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(src, []byte("x"), 0644)
+	o.AddFile("laptop", src)
+	old, _ := o.Pending("laptop")
+	time.Sleep(50 * time.Millisecond)
+	o.AddMessage("laptop", "fresh")
+
+	items, err := o.Pending("laptop")
+	if err != nil || len(items) != 1 || items[0].Text != "fresh" {
+		t.Errorf("Pending = %+v, %v; want only the fresh message", items, err)
+	}
+	if _, err := os.Stat(old[0].DataPath()); !os.IsNotExist(err) {
+		t.Errorf("expired file data left: %v", err)
+	}
+
+	// A corrupt item is removed rather than blocking the queue.
+	bad := filepath.Join(o.Dir, "laptop", "0-bad.json")
+	os.WriteFile(bad, []byte("{"), 0600)
+	if items, _ := o.Pending("laptop"); len(items) != 1 {
+		t.Errorf("Pending = %+v", items)
+	}
+	if _, err := os.Stat(bad); !os.IsNotExist(err) {
+		t.Errorf("corrupt item left: %v", err)
+	}
+}
+
+// peer is a test server that answers each POST by its text or file name: "refuse"
+// gets 403, "busy" gets 500, anything else is accepted and recorded.
+type peer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received []string
+}
+
+func newPeer(t *testing.T) *peer {
+	p := &peer{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got string
+		switch r.URL.Path {
+		case "/clipboard":
+			b, _ := io.ReadAll(r.Body)
+			got = "clipboard:" + string(b)
+		case "/message":
+			var req struct{ Text string }
+			json.NewDecoder(r.Body).Decode(&req)
+			got = "message:" + req.Text
+		case "/files":
+			f, h, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			b, _ := io.ReadAll(f)
+			got = "file:" + h.Filename + ":" + string(b)
+		}
+		switch {
+		case strings.Contains(got, "refuse"):
+			http.Error(w, "no", http.StatusForbidden)
+			return
+		case strings.Contains(got, "busy"):
+			http.Error(w, "later", http.StatusInternalServerError)
+			return
+		}
+		p.mu.Lock()
+		p.received = append(p.received, got+" from "+r.Header.Get("X-From-Host"))
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *peer) take() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.received
+	p.received = nil
+	return r
+}
+
+func pendingTexts(o *Outbox, peer string) []string {
+	items, _ := o.Pending(peer)
+	var s []string
+	for _, it := range items {
+		s = append(s, it.Text+it.Name)
+	}
+	return s
+}
+
+func TestDeliver(t *testing.T) {
+	o := open(t, 0)
+	p := newPeer(t)
+	online := true
+	opts := &DeliverOptions{
+		Targets: func() []Target {
+			if !online {
+				return nil
+			}
+			return []Target{{Names: []string{"laptop", "100.64.0.9"}, BaseURL: p.URL + "/"}}
+		},
+		HTTPClient:  p.Client(),
+		GetFromHost: func() string { return "desk" },
+	}
+	ctx := context.Background()
+	big := strings.Repeat("0123456789", 100000)
+	src := filepath.Join(t.TempDir(), "big.txt")
+	os.WriteFile(src, []byte(big), 0644)
+
+	o.AddMessage("laptop", "refuse me")
+	o.AddMessage("laptop.local", "one")
+	o.AddFile("laptop", src)
+	o.PutClipboard("laptop", "clip", nil)
+
+	online = false
+	o.deliverAll(ctx, opts)
+	if got := p.take(); got != nil {
+		t.Errorf("delivered %q to an offline peer", got)
+	}
+
+	// A refused item is dropped; the ones behind it still go out.
+	online = true
+	o.deliverAll(ctx, opts)
+	want := []string{"message:one from desk", "file:big.txt:" + big + " from desk", "clipboard:clip from desk"}
+	if got := p.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %d items, want %d", len(got), len(want))
+	}
+	if got := pendingTexts(o, "laptop"); got != nil {
+		t.Errorf("pending after delivery: %q", got)
+	}
+
+	// A server error keeps the item and everything behind it for the next pass.
+	o.AddMessage("laptop", "busy")
+	o.AddMessage("laptop", "two")
+	o.deliverAll(ctx, opts)
+	if got := p.take(); got != nil {
+		t.Errorf("delivered %q past a failed item", got)
+	}
+	if got := pendingTexts(o, "laptop"); !reflect.DeepEqual(got, []string{"busy", "two"}) {
+		t.Errorf("pending after a 500: %q", got)
+	}
+	items, _ := o.Pending("laptop")
+	o.Remove(items[0])
+
+	// A file item whose data is gone is dropped.
+	o.AddFile("laptop", src)
+	items, _ = o.Pending("laptop")
+	os.Remove(items[1].DataPath())
+	o.deliverAll(ctx, opts)
+	if got := p.take(); !reflect.DeepEqual(got, []string{"message:two from desk"}) {
+		t.Errorf("delivered %q", got)
+	}
+	if got := pendingTexts(o, "laptop"); got != nil {
+		t.Errorf("pending after a missing file: %q", got)
+	}
+
+	// Clipboard items wait while CanSend says no; messages do not.
+	opts.CanSend = func() bool { return false }
+	o.PutClipboard("laptop", "held", nil)
+	o.AddMessage("laptop", "three")
+	o.deliverAll(ctx, opts)
+	if got := p.take(); !reflect.DeepEqual(got, []string{"message:three from desk"}) {
+		t.Errorf("delivered %q while paused", got)
+	}
+	if got := pendingTexts(o, "laptop"); !reflect.DeepEqual(got, []string{"held"}) {
+		t.Errorf("pending while paused: %q", got)
+	}
+}
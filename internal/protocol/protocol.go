@@ -0,0 +1,209 @@
+// Package protocol defines the versioned xconnect API: the /v1 path prefix, the
+// protocol version exchanged in X-XConnect-Protocol, and the capabilities a server
+// announces on GET /v1/hello so clients and peers can pick features.
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Version is the protocol spoken by this release.
+	Version = 1
+	// MinVersion is the oldest client protocol a server of this release accepts.
+	MinVersion = 1
+	// Prefix is the path prefix of the versioned API; the unprefixed paths are legacy aliases.
+	Prefix = "/v1"
+	// Header carries the sender's protocol version on requests and responses.
+	Header = "X-XConnect-Protocol"
+	// HelloPath is the capability handshake endpoint.
+	HelloPath = Prefix + "/hello"
+)
+
+// Capabilities.
+const (
+	CapClipboardVersion = "clipboard-version" // X-XConnect-Origin/Clock/Hash loop prevention
+	CapClipboardWait    = "clipboard-wait"    // GET /clipboard ETag and ?wait= long-polling
+	CapEvents           = "clipboard-events"  // GET /clipboard/events (SSE)
+	CapPrimary          = "primary"           // POST /clipboard/primary
+	CapPending          = "pending"           // /clipboard/pending approval queue
+	CapSyncControl      = "sync-control"      // /sync/pause, /sync/resume, /sync/mode
+	CapFiles            = "files"             // POST /files, GET /files/{id}
+	CapMessage          = "message"           // POST /message
+	CapStatus           = "status"            // GET /status health report
+)
+
+// Hello is a server's answer to the handshake.
+type Hello struct {
+	Protocol     int      `json:"protocol"`
+	MinProtocol  int      `json:"min_protocol"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Legacy is the Hello assumed for servers older than the handshake (no /v1/hello):
+// unprefixed paths and only the features every release had.
+func Legacy() *Hello {
+	return &Hello{Capabilities: []string{CapFiles, CapMessage}}
+}
+
+// Has reports whether the server announced capability c.
+func (h *Hello) Has(c string) bool {
+	for _, x := range h.Capabilities {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the path to use for the API path p (e.g. "/clipboard") on this server.
+func (h *Hello) Path(p string) string {
+	if h.Protocol >= 1 {
+		return Prefix + p
+	}
+	return p
+}
+
+// IncompatibleError is returned when this release and a server cannot talk.
+type IncompatibleError struct {
+	Peer     string // base URL
+	Protocol int    // the server's protocol
+	Min      int    // the oldest client protocol the server accepts
+}
+
+func (e *IncompatibleError) Error() string {
+	switch {
+	case e.Peer == "":
+		return fmt.Sprintf("client protocol %d is older than %d; upgrade the client", e.Protocol, e.Min)
+	case e.Min > Version:
+		return fmt.Sprintf("%s needs protocol %d or newer (we speak %d); upgrade xconnect here", e.Peer, e.Min, Version)
+	}
+	return fmt.Sprintf("%s speaks protocol %d, older than %d; upgrade xconnect there", e.Peer, e.Protocol, MinVersion)
+}
+
+// Check validates a request's Header value (empty = legacy client). It returns an
+// *IncompatibleError for clients too old to serve, or a plain error when malformed.
+func Check(header string) error {
+	if header == "" {
+		return nil // legacy clients use the unprefixed aliases
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || v < 0 {
+		return fmt.Errorf("malformed %s header %q", Header, header)
+	}
+	if v < MinVersion {
+		return &IncompatibleError{Protocol: v, Min: MinVersion}
+	}
+	return nil
+}
+
+// SetHeader marks req as sent by this release.
+func SetHeader(req *http.Request) {
+	req.Header.Set(Header, strconv.Itoa(Version))
+}
+
+// Handshake fetches the Hello of the server at base. Servers from before the
+// handshake yield Legacy(); servers that cannot talk to this release yield an
+// *IncompatibleError.
+func Handshake(ctx context.Context, client *http.Client, base string) (*Hello, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	base = strings.TrimSuffix(base, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", base+HelloPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	SetHeader(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Legacy(), nil
+	case http.StatusUpgradeRequired:
+		var h Hello
+		json.NewDecoder(resp.Body).Decode(&h)
+		return nil, &IncompatibleError{Peer: base, Protocol: h.Protocol, Min: h.MinProtocol}
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("GET %s%s: %s %s", base, HelloPath, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var h Hello
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		return nil, fmt.Errorf("GET %s%s: %w", base, HelloPath, err)
+	}
+	if h.MinProtocol > Version || h.Protocol < MinVersion {
+		return nil, &IncompatibleError{Peer: base, Protocol: h.Protocol, Min: h.MinProtocol}
+	}
+	return &h, nil
+}
+
+// IsIncompatible reports whether err is (or wraps) an *IncompatibleError.
+func IsIncompatible(err error) bool {
+	var ie *IncompatibleError
+	return errors.As(err, &ie)
+}
+
+// Cache remembers handshakes per server, so peers are asked once per TTL rather than
+// before every request. Failed handshakes are not cached. The zero value is ready.
+type Cache struct {
+	Client *http.Client  // default http.DefaultClient
+	TTL    time.Duration // default 10m
+
+	mu sync.Mutex
+	m  map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	hello *Hello
+	at    time.Time
+}
+
+// Get returns the Hello of the server at base, from the cache when fresh.
+func (c *Cache) Get(ctx context.Context, base string) (*Hello, error) {
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	c.mu.Lock()
+	e, ok := c.m[base]
+	c.mu.Unlock()
+	if ok && time.Since(e.at) < ttl {
+		return e.hello, nil
+	}
+	h, err := Handshake(ctx, c.Client, base)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.m == nil {
+		c.m = make(map[string]cacheEntry)
+	}
+	c.m[base] = cacheEntry{hello: h, at: time.Now()}
+	c.mu.Unlock()
+	return h, nil
+}
+
+// Forget drops base from the cache, e.g. after a request failed, so the next Get
+// repeats the handshake (the peer may have been upgraded).
+func (c *Cache) Forget(base string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.m, base)
+	c.mu.Unlock()
+}
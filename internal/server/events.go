@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	clipsync "github.com/xconnect/xconnect-go/internal/sync"
+)
+
+// eventsPing is how often an idle event stream gets a comment line, so proxies and
+// NAT mappings keep the connection open.
+const eventsPing = 25 * time.Second
+
+// getEvents streams this node's local clipboard copies as server-sent events:
+//
+//	id: <hash>
+//	event: clipboard
+//	data: {"origin":...,"clock":...,"hash":...,"content":...}
+//
+// The current copy is sent first unless the client already has it (Last-Event-ID or
+// If-None-Match equal to its hash), so a reconnecting subscriber resumes without
+// missing the latest update.
+func (h *handler) getEvents(w http.ResponseWriter, r *http.Request) {
+	if h.opts == nil || h.opts.Events == nil {
+		http.Error(w, "clipboard events not enabled", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = strings.Trim(r.Header.Get("If-None-Match"), `"`)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPing)
+	defer ping.Stop()
+	for {
+		ev, changed := h.opts.Events.Current()
+		if ev.Hash != "" && ev.ID() != last {
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: clipboard\ndata: %s\n\n", ev.ID(), ev.Data()); err != nil {
+				return
+			}
+			flusher.Flush()
+			clipsync.ClipboardBytes.Add(float64(len(ev.Content)), "sent")
+			last = ev.ID()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.closing:
+			return
+		case <-changed:
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
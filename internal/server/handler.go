@@ -4,47 +4,241 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/xconnect/xconnect-go/internal/approval"
 	"github.com/xconnect/xconnect-go/internal/clipboard"
+	"github.com/xconnect/xconnect-go/internal/metrics"
+	"github.com/xconnect/xconnect-go/internal/protocol"
+	clipsync "github.com/xconnect/xconnect-go/internal/sync"
 )
 
 const (
-	defaultFileDir      = "xconnect-files"
+	defaultFileDir       = "xconnect-files"
 	clipboardHistorySize = 50
+	// GET /clipboard?wait= is capped at maxClipboardWait. Without sync, waiting requests
+	// share a clipboard watcher, which polls every clipboardWatchPoll where the
+	// platform has no change notifications.
+	maxClipboardWait   = 5 * time.Minute
+	clipboardWatchPoll = 500 * time.Millisecond
 )
 
+// ErrRefused, wrapped in an error returned by ReceiveClipboard or ReceivePrimary,
+// answers 403: the content was deliberately not written (e.g. it is blocked by a
+// filter) and the sender, typically a user running `xconnect push`, should be told.
+var ErrRefused = errors.New("refused")
+
 // HandlerOpts optionally configures the handler (e.g. for clipboard sync).
 type HandlerOpts struct {
 	// OnClipboardReceivedFromNetwork is called when we write clipboard content received from a peer.
 	// Used by sync to avoid re-broadcasting that content.
 	OnClipboardReceivedFromNetwork func(content string)
+	// ReceiveClipboard, if set, decides whether content received on POST /clipboard is
+	// written to the local clipboard (with write). It returns false with a reason for
+	// duplicate or stale updates, which are acknowledged but not written or recorded,
+	// and an error wrapping ErrRefused for content the sender should hear was refused.
+	ReceiveClipboard func(r *http.Request, content string, write func(string) error) (applied bool, reason string, err error)
+	// ReceivePrimary is ReceiveClipboard for the PRIMARY selection (POST /clipboard/primary);
+	// nil = PRIMARY sync disabled (404).
+	ReceivePrimary func(r *http.Request, content string, write func(string) error) (applied bool, reason string, err error)
+	// Sync, if set, is controlled through /sync/pause, /sync/resume, /sync/mode and /sync/status.
+	Sync *clipsync.Control
+	// Pending, if set, holds clipboard updates awaiting approval; served on /clipboard/pending.
+	Pending *approval.Queue
+	// Events, if set, publishes local copies on GET /clipboard/events (SSE) for peers
+	// that subscribe instead of being pushed to.
+	Events *clipsync.Feed
+	// Status, if set, adds the node's version, listener, peers and sync counters to
+	// the GET /status report.
+	Status func(*Status)
+	// Storage, if set, holds the received files directory and the clipboard history
+	// limits; nil = xconnect-files and 50 entries.
+	Storage *Storage
+	// RemoteStatus serves GET /status and GET /metrics to every caller; by default
+	// they are local-only, as they list the peers, their errors and the files dir.
+	// See Handler.SetRemoteStatus.
+	RemoteStatus bool
+	// ClipboardReads is set when the caller watches the clipboard itself and reports
+	// every read through Handler.ClipboardRead; GET /clipboard?wait= then wakes on
+	// those instead of starting a watcher of its own.
+	ClipboardReads bool
+}
+
+// Storage is where received files are saved and how much clipboard history is kept.
+// Like clipsync.Control, it may be changed while serving (e.g. on config reload);
+// files and history entries already kept are not moved.
+type Storage struct {
+	mu         sync.Mutex
+	fileDir    string
+	historyMax int
+	historyAge time.Duration
+}
+
+// NewStorage returns a Storage; see Set.
+func NewStorage(fileDir string, historySize int, historyAge time.Duration) *Storage {
+	s := &Storage{}
+	s.Set(fileDir, historySize, historyAge)
+	return s
+}
+
+// Set changes the files directory (empty = xconnect-files in the working directory),
+// the number of history entries (0 = 50) and their maximum age (0 = no limit).
+func (s *Storage) Set(fileDir string, historySize int, historyAge time.Duration) {
+	if fileDir == "" {
+		fileDir = defaultFileDir
+	}
+	if historySize <= 0 {
+		historySize = clipboardHistorySize
+	}
+	s.mu.Lock()
+	s.fileDir, s.historyMax, s.historyAge = fileDir, historySize, historyAge
+	s.mu.Unlock()
+}
+
+// FileDir returns the received files directory.
+func (s *Storage) FileDir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fileDir
+}
+
+func (s *Storage) history() (max int, age time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.historyMax, s.historyAge
+}
+
+// Handler serves the xconnect API.
+type Handler struct {
+	http.Handler
+	h *handler
+}
+
+// ApplyRemote records content from peer from, written to the clipboard outside a
+// request (e.g. from the peer's event stream), as an applied POST /clipboard would:
+// in clipboard history and through OnClipboardReceivedFromNetwork.
+func (s *Handler) ApplyRemote(from, content string) {
+	s.h.received(from, content)
 }
 
-// NewHandler returns an http.Handler for the xconnect API.
+// SetRemoteStatus changes HandlerOpts.RemoteStatus while serving (e.g. on config reload).
+func (s *Handler) SetRemoteStatus(on bool) {
+	s.h.remoteStatus.Store(on)
+}
+
+// ClipboardRead reports content just read from the local clipboard, waking
+// GET /clipboard?wait= requests if it changed (see HandlerOpts.ClipboardReads).
+func (s *Handler) ClipboardRead(content string) {
+	s.h.watch.set(content)
+}
+
+// NewHandler returns a Handler for the xconnect API, mounted under /v1 with the
+// unprefixed legacy paths as aliases.
 // If opts is nil, no optional behaviour is used.
-func NewHandler(opts *HandlerOpts) http.Handler {
+func NewHandler(opts *HandlerOpts) *Handler {
 	mux := http.NewServeMux()
 	h := &handler{
-		fileDir:  defaultFileDir,
-		files:    make(map[string]string),
-		opts:     opts,
-		clipHist: make([]ClipboardHistoryEntry, 0, clipboardHistorySize),
+		files:   make(map[string]string),
+		opts:    opts,
+		closing: make(chan struct{}),
+	}
+	if opts != nil && opts.Storage != nil {
+		h.storage = opts.Storage
+	} else {
+		h.storage = NewStorage("", 0, 0)
+	}
+	h.watch = newClipWatch(opts != nil && opts.ClipboardReads)
+	h.remoteStatus.Store(opts != nil && opts.RemoteStatus)
+	if err := h.load(); err != nil {
+		slog.Warn("server: history and files index not restored", "dir", h.storage.FileDir(), "err", err)
+	}
+	handle := func(pattern string, f http.HandlerFunc) {
+		mux.Handle(pattern, instrument(pattern, withDeadline(pattern, f).ServeHTTP))
+	}
+	handle("GET /clipboard", h.getClipboard)
+	handle("POST /clipboard", h.postClipboard)
+	handle("GET /clipboard/history", h.getClipboardHistory)
+	handle("GET /clipboard/events", h.getEvents)
+	handle("POST /clipboard/primary", h.postPrimary)
+	handle("GET /clipboard/pending", localOnly(h.getPending))
+	handle("POST /clipboard/pending/{id}/accept", localOnly(h.decidePending(true)))
+	handle("POST /clipboard/pending/{id}/reject", localOnly(h.decidePending(false)))
+	handle("POST /files", h.postFiles)
+	handle("GET /files/", h.getFile)
+	handle("POST /message", h.postMessage)
+	handle("GET /ws", h.serveWebSocket)
+	handle("GET /sync/status", h.syncControl(nil))
+	handle("POST /sync/pause", localOnly(h.syncControl(func(c *clipsync.Control, r *http.Request) error { c.Pause(); return nil })))
+	handle("POST /sync/resume", localOnly(h.syncControl(func(c *clipsync.Control, r *http.Request) error { c.Resume(); return nil })))
+	handle("POST /sync/mode", localOnly(h.syncControl(setSyncMode)))
+	handle("GET /hello", h.hello)
+	handle("GET /status", h.statusRoute(h.getStatus))
+	handle("GET /metrics", h.statusRoute(metrics.Handler().ServeHTTP))
+	// Every route is served under /v1; the unprefixed paths remain as aliases for
+	// clients and peers from before the handshake.
+	root := http.NewServeMux()
+	root.Handle(protocol.Prefix+"/", http.StripPrefix(protocol.Prefix, mux))
+	root.Handle("/", mux)
+	return &Handler{Handler: withDeadline("", checkProtocol(root)), h: h}
+}
+
+// checkProtocol rejects requests from clients whose X-XConnect-Protocol this server
+// cannot serve (426) or cannot parse (400), and stamps every response with ours.
+func checkProtocol(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(protocol.Header, strconv.Itoa(protocol.Version))
+		if err := protocol.Check(r.Header.Get(protocol.Header)); err != nil {
+			if !protocol.IsIncompatible(err) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Upgrade", "xconnect/"+strconv.Itoa(protocol.Version))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUpgradeRequired)
+			json.NewEncoder(w).Encode(struct {
+				protocol.Hello
+				Error string `json:"error"`
+			}{Hello: protocol.Hello{Protocol: protocol.Version, MinProtocol: protocol.MinVersion}, Error: err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hello answers the capability handshake (GET /v1/hello) with the protocol versions
+// and the features enabled on this server.
+func (h *handler) hello(w http.ResponseWriter, r *http.Request) {
+	caps := []string{protocol.CapClipboardWait, protocol.CapFiles, protocol.CapMessage, protocol.CapStatus}
+	if o := h.opts; o != nil {
+		if o.ReceiveClipboard != nil {
+			caps = append(caps, protocol.CapClipboardVersion)
+		}
+		if o.Events != nil {
+			caps = append(caps, protocol.CapEvents)
+		}
+		if o.ReceivePrimary != nil {
+			caps = append(caps, protocol.CapPrimary)
+		}
+		if o.Pending != nil {
+			caps = append(caps, protocol.CapPending)
+		}
+		if o.Sync != nil {
+			caps = append(caps, protocol.CapSyncControl)
+		}
 	}
-	mux.HandleFunc("GET /clipboard", h.getClipboard)
-	mux.HandleFunc("POST /clipboard", h.postClipboard)
-	mux.HandleFunc("GET /clipboard/history", h.getClipboardHistory)
-	mux.HandleFunc("POST /files", h.postFiles)
-	mux.HandleFunc("GET /files/", h.getFile)
-	mux.HandleFunc("POST /message", h.postMessage)
-	mux.HandleFunc("GET /ws", h.serveWebSocket)
-	return mux
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.Hello{Protocol: protocol.Version, MinProtocol: protocol.MinVersion, Capabilities: caps})
 }
 
 // ClipboardHistoryEntry is one item in clipboard history (for GUI).
@@ -55,21 +249,108 @@ type ClipboardHistoryEntry struct {
 }
 
 type handler struct {
-	fileDir   string
-	mu        sync.Mutex
-	files     map[string]string
-	opts      *HandlerOpts
-	clipHist  []ClipboardHistoryEntry
+	storage  *Storage
+	mu       sync.Mutex
+	files    map[string]string
+	opts     *HandlerOpts
+	clipHist []ClipboardHistoryEntry
+	watch    *clipWatch
+	usage    usageCache
+	// remoteStatus is HandlerOpts.RemoteStatus, changeable with SetRemoteStatus.
+	remoteStatus atomic.Bool
+	closing      chan struct{} // closed when the server shuts down; see NewServer
+	closeOnce    sync.Once
 }
 
+// shutdown ends long-polls and event streams.
+func (h *handler) shutdown() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+// getClipboard returns the clipboard with its content hash as ETag. When the
+// If-None-Match ETag still matches it answers 304; with ?wait=30s it first waits up to
+// that long (at most maxClipboardWait) for the clipboard to change.
 func (h *handler) getClipboard(w http.ResponseWriter, r *http.Request) {
+	wait, err := parseWait(r.URL.Query().Get("wait"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inm := r.Header.Get("If-None-Match")
+	if wait > 0 {
+		// Register before reading, so a change right after the read still wakes us.
+		defer h.watch.wait()()
+	}
 	text, err := clipboard.ReadAll()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Write([]byte(text))
+	h.watch.set(text)
+	_, changed := h.watch.latest()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		etag := `"` + clipsync.Hash(text) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if !etagMatch(inm, etag) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(text))
+			clipsync.ClipboardBytes.Add(float64(len(text)), "sent")
+			return
+		}
+		if wait <= 0 {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		select {
+		case <-r.Context().Done(): // client gone
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-h.closing: // server shutting down: "no change yet"
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-timer.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-changed:
+		}
+		text, changed = h.watch.latest()
+	}
+}
+
+// parseWait parses ?wait= as a duration ("30s") or a number of seconds ("30").
+func parseWait(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		n, nerr := strconv.Atoi(s)
+		if nerr != nil {
+			return 0, fmt.Errorf("wait: %w", err)
+		}
+		d = time.Duration(n) * time.Second
+	}
+	if d < 0 {
+		return 0, errors.New("wait: negative duration")
+	}
+	if d > maxClipboardWait {
+		d = maxClipboardWait
+	}
+	return d, nil
+}
+
+// etagMatch reports whether the If-None-Match header value inm matches etag.
+func etagMatch(inm, etag string) bool {
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag || `"`+t+`"` == etag {
+			return true
+		}
+	}
+	return false
 }
 
 func fromHost(r *http.Request) string {
@@ -88,13 +369,27 @@ func (h *handler) appendClipboardHistory(content, fromHost string) {
 	defer h.mu.Unlock()
 	entry := ClipboardHistoryEntry{Content: content, FromHost: fromHost, At: time.Now().UTC()}
 	h.clipHist = append(h.clipHist, entry)
-	if len(h.clipHist) > clipboardHistorySize {
-		h.clipHist = h.clipHist[len(h.clipHist)-clipboardHistorySize:]
+	h.trimHistory()
+}
+
+// trimHistory drops the entries beyond the Storage limits; h.mu must be held.
+func (h *handler) trimHistory() {
+	max, age := h.storage.history()
+	if len(h.clipHist) > max {
+		h.clipHist = h.clipHist[len(h.clipHist)-max:]
+	}
+	if age > 0 {
+		cutoff := time.Now().Add(-age)
+		i := 0
+		for i < len(h.clipHist) && h.clipHist[i].At.Before(cutoff) {
+			i++
+		}
+		h.clipHist = h.clipHist[i:]
 	}
+	historyEntries.Set(float64(len(h.clipHist)))
 }
 
 func (h *handler) postClipboard(w http.ResponseWriter, r *http.Request) {
-	fromHost := fromHost(r)
 	ct := r.Header.Get("Content-Type")
 	if strings.HasPrefix(ct, "multipart/form-data") {
 		// Optional: image or file in form
@@ -104,15 +399,7 @@ func (h *handler) postClipboard(w http.ResponseWriter, r *http.Request) {
 		}
 		// Prefer text field
 		if t := r.FormValue("text"); t != "" {
-			if err := clipboard.WriteAll(t); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			h.appendClipboardHistory(t, fromHost)
-			if h.opts != nil && h.opts.OnClipboardReceivedFromNetwork != nil {
-				h.opts.OnClipboardReceivedFromNetwork(t)
-			}
-			w.WriteHeader(http.StatusNoContent)
+			h.receiveClipboard(w, r, t)
 			return
 		}
 		// TODO: image clipboard if needed per platform
@@ -123,20 +410,78 @@ func (h *handler) postClipboard(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	content := string(body)
-	if err := clipboard.WriteAll(content); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	h.receiveClipboard(w, r, string(body))
+}
+
+// receiveClipboard writes content from a peer to the clipboard (through
+// opts.ReceiveClipboard when set) and records it in history.
+func (h *handler) receiveClipboard(w http.ResponseWriter, r *http.Request, content string) {
+	clipsync.ClipboardBytes.Add(float64(len(content)), "received")
+	applied, reason := true, ""
+	var err error
+	if h.opts != nil && h.opts.ReceiveClipboard != nil {
+		applied, reason, err = h.opts.ReceiveClipboard(r, content, clipboard.WriteAll)
+	} else {
+		err = clipboard.WriteAll(content)
+	}
+	if err != nil {
+		receiveError(w, err)
+		return
+	}
+	if !applied {
+		w.Header().Set("X-XConnect-Ignored", reason)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	h.appendClipboardHistory(content, fromHost)
+	h.received(fromHost(r), content)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// received records content from a peer that was written to the clipboard.
+func (h *handler) received(from, content string) {
+	h.watch.set(content)
+	h.appendClipboardHistory(content, from)
 	if h.opts != nil && h.opts.OnClipboardReceivedFromNetwork != nil {
 		h.opts.OnClipboardReceivedFromNetwork(content)
 	}
+}
+
+// receiveError answers a failed ReceiveClipboard: 403 for refused content, else 500.
+func receiveError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrRefused) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// postPrimary sets the PRIMARY selection from a peer. Selections are not recorded in
+// clipboard history.
+func (h *handler) postPrimary(w http.ResponseWriter, r *http.Request) {
+	if h.opts == nil || h.opts.ReceivePrimary == nil {
+		http.Error(w, "PRIMARY selection sync not enabled", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clipsync.ClipboardBytes.Add(float64(len(body)), "received")
+	applied, reason, err := h.opts.ReceivePrimary(r, string(body), clipboard.WritePrimary)
+	if err != nil {
+		receiveError(w, err)
+		return
+	}
+	if !applied {
+		w.Header().Set("X-XConnect-Ignored", reason)
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *handler) getClipboardHistory(w http.ResponseWriter, r *http.Request) {
 	h.mu.Lock()
+	h.trimHistory()
 	list := make([]ClipboardHistoryEntry, len(h.clipHist))
 	copy(list, h.clipHist)
 	h.mu.Unlock()
@@ -148,6 +493,49 @@ func (h *handler) getClipboardHistory(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(list)
 }
 
+func (h *handler) getPending(w http.ResponseWriter, r *http.Request) {
+	list := []approval.Pending{}
+	if h.opts != nil && h.opts.Pending != nil {
+		list = h.opts.Pending.List()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// decidePending accepts or rejects the pending item {id} ("latest" = the newest).
+func (h *handler) decidePending(accept bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.opts == nil || h.opts.Pending == nil {
+			http.Error(w, approval.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		id := r.PathValue("id")
+		if id == "latest" {
+			id = ""
+		}
+		var p approval.Pending
+		var err error
+		if accept {
+			p, err = h.opts.Pending.Accept(id)
+		} else {
+			p, err = h.opts.Pending.Reject(id)
+		}
+		switch {
+		case errors.Is(err, approval.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if accept {
+			h.received(p.From, p.Content)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
 type fileResponse struct {
 	ID string `json:"file_id"`
 }
@@ -157,7 +545,8 @@ func (h *handler) postFiles(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := os.MkdirAll(h.fileDir, 0700); err != nil {
+	dir := h.storage.FileDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -185,14 +574,14 @@ func (h *handler) postFiles(w http.ResponseWriter, r *http.Request) {
 			if ext == "" {
 				ext = ".bin"
 			}
-			path = filepath.Join(h.fileDir, id+ext)
+			path = filepath.Join(dir, id+ext)
 			out, err := os.Create(path)
 			if err != nil {
 				f.Close()
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			_, err = io.Copy(out, f)
+			written, err := io.Copy(out, f)
 			out.Close()
 			f.Close()
 			if err != nil {
@@ -200,6 +589,7 @@ func (h *handler) postFiles(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			fileBytes.Add(float64(written), "upload")
 			break
 		}
 		if id != "" {
@@ -217,6 +607,7 @@ func (h *handler) postFiles(w http.ResponseWriter, r *http.Request) {
 		h.files[id+":name"] = filename
 	}
 	h.mu.Unlock()
+	h.usage.invalidate()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(fileResponse{ID: id})
@@ -229,18 +620,23 @@ func (h *handler) getFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing file id", http.StatusBadRequest)
 		return
 	}
+	if strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) { // e.g. indexFile
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
 	h.mu.Lock()
 	path, ok := h.files[id]
 	origName := h.files[id+":name"]
 	h.mu.Unlock()
 	if !ok {
-		path = filepath.Join(h.fileDir, id)
+		dir := h.storage.FileDir()
+		path = filepath.Join(dir, id)
 		if _, err := os.Stat(path); err != nil {
-			entries, _ := os.ReadDir(h.fileDir)
+			entries, _ := os.ReadDir(dir)
 			for _, e := range entries {
 				base := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
 				if base == id {
-					path = filepath.Join(h.fileDir, e.Name())
+					path = filepath.Join(dir, e.Name())
 					origName = e.Name()
 					break
 				}
@@ -263,7 +659,9 @@ func (h *handler) getFile(w http.ResponseWriter, r *http.Request) {
 		name = info.Name()
 	}
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
-	http.ServeContent(w, r, name, info.ModTime(), f)
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, name, info.ModTime(), f)
+	fileBytes.Add(float64(cw.n), "download")
 }
 
 type messageRequest struct {
@@ -276,13 +674,49 @@ func (h *handler) postMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	// Write to clipboard as the "message" delivery
+	// Write to clipboard as the "message" delivery, recorded like a POST /clipboard
 	if req.Text != "" {
-		_ = clipboard.WriteAll(req.Text)
+		h.receiveClipboard(w, r, req.Text)
+		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// syncControl applies change (if any) to the sync Control and responds with its status.
+func (h *handler) syncControl(change func(*clipsync.Control, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.opts == nil || h.opts.Sync == nil {
+			http.Error(w, "sync control not available", http.StatusNotFound)
+			return
+		}
+		if change != nil {
+			if err := change(h.opts.Sync, r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.opts.Sync.Status())
+	}
+}
+
+type syncModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+// setSyncMode takes the mode from ?mode= or a JSON body {"mode": "..."}.
+func setSyncMode(c *clipsync.Control, r *http.Request) error {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		var req syncModeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		mode = req.Mode
+	}
+	return c.SetMode(mode)
+}
+
 func (h *handler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Simple WebSocket upgrade placeholder; full impl would use gorilla/websocket or nhooyr.io
 	http.Error(w, "WebSocket not implemented; use POST /clipboard or POST /message", http.StatusNotImplemented)
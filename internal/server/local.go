@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net"
+	"net/http"
+)
+
+// localOnly serves next only to callers on this machine: loopback or a Unix socket.
+// Other callers get 403. These routes show or control this device (held content,
+// approving it, pausing sync), which no tailnet peer may do; with -tsnet, where every
+// caller comes in over the tailnet, they are therefore unavailable.
+func localOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isLocal(r) {
+			http.Error(w, "only available to local callers", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isLocal reports whether r came from this machine.
+func isLocal(r *http.Request) bool {
+	if la, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && la.Network() == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// statusRoute serves next to local callers, and to everyone with remoteStatus.
+func (h *handler) statusRoute(next http.HandlerFunc) http.HandlerFunc {
+	local := localOnly(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.remoteStatus.Load() {
+			next(w, r)
+			return
+		}
+		local(w, r)
+	}
+}
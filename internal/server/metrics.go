@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/metrics"
+)
+
+var (
+	httpRequests   = metrics.NewCounter("xconnect_http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration   = metrics.NewHistogram("xconnect_http_request_duration_seconds", "HTTP request latency by route (long-polls and event streams included).", nil, "route")
+	fileBytes      = metrics.NewCounter("xconnect_file_bytes_total", "File bytes uploaded to (upload) and downloaded from (download) this node.", "direction")
+	historyEntries = metrics.NewGauge("xconnect_clipboard_history_entries", "Entries in the clipboard history.")
+)
+
+// instrument counts and times requests to the route registered as pattern
+// (e.g. "GET /clipboard").
+func instrument(pattern string, next http.HandlerFunc) http.Handler {
+	route := pattern
+	if i := strings.IndexByte(route, ' '); i >= 0 {
+		route = route[i+1:]
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next(rec, r)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.code))
+		httpDuration.Since(start, route)
+	})
+}
+
+// statusRecorder remembers the response status; it keeps Flush working for event streams.
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	wrote bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wrote {
+		s.code, s.wrote = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wrote = true
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	s.wrote = true
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// countingWriter counts the body bytes written.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.n += int64(n)
+	return n, err
+}
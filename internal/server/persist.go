@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexFile keeps the clipboard history and the received files index in the files
+// directory across restarts. GET /files never serves it, nor any other dotfile.
+const indexFile = ".xconnect-index.json"
+
+// index is the content of indexFile.
+type index struct {
+	History []ClipboardHistoryEntry `json:"history"`
+	Files   map[string]string       `json:"files"` // file ID (and ID+":name") to path
+}
+
+// Save writes the clipboard history and the received files index to the files
+// directory, for NewHandler to load on the next start. The file is readable by the
+// owner only (0600), as the history holds clipboard content.
+func (s *Handler) Save() error {
+	h := s.h
+	h.mu.Lock()
+	h.trimHistory()
+	idx := index{History: append([]ClipboardHistoryEntry(nil), h.clipHist...), Files: maps.Clone(h.files)}
+	h.mu.Unlock()
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	dir := h.storage.FileDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, indexFile+".*") // 0600
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, indexFile))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// load restores what Save wrote, if anything. Files that are gone are left out and
+// the history is trimmed to the current limits.
+func (h *handler) load() error {
+	b, err := os.ReadFile(filepath.Join(h.storage.FileDir(), indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, path := range idx.Files {
+		if strings.HasSuffix(id, ":name") {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		h.files[id] = path
+		if name, ok := idx.Files[id+":name"]; ok {
+			h.files[id+":name"] = name
+		}
+	}
+	h.clipHist = idx.History
+	h.trimHistory()
+	return nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts of the API server. There is no server-wide WriteTimeout: GET /clipboard?wait=
+// long-polls for up to maxClipboardWait and GET /clipboard/events streams for as long
+// as the subscriber stays, so each route sets its own deadlines (see routeTimeout).
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+	requestTimeout    = 30 * time.Second // reading and answering an ordinary request
+	transferTimeout   = 10 * time.Minute // file uploads and downloads
+)
+
+// NewServer returns an http.Server for h with the API's timeouts. Shutdown ends
+// long-polls and event streams at once instead of waiting for them, and waits for
+// the other requests, such as uploads, to finish; their contexts stay valid.
+func NewServer(h *Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	srv.RegisterOnShutdown(h.h.shutdown)
+	return srv
+}
+
+// Socket returns the listening socket of ln, to Serve on: closing it (as Shutdown does)
+// leaves an embedded Tailscale node up, so draining requests can still complete.
+// Close ln afterwards.
+func Socket(ln net.Listener) net.Listener {
+	if t, ok := ln.(*tsnetListener); ok {
+		return t.Listener
+	}
+	return ln
+}
+
+// routeTimeout is the read and write deadline of a request to pattern; 0 = none.
+func routeTimeout(pattern string) time.Duration {
+	switch pattern {
+	case "GET /clipboard/events", "GET /ws":
+		return 0
+	case "GET /clipboard":
+		return maxClipboardWait + requestTimeout
+	case "POST /files", "GET /files/":
+		return transferTimeout
+	}
+	return requestTimeout
+}
+
+// withDeadline sets the connection's deadlines for a request to pattern ("" for
+// requests no route matched). They are set on every request, since the server
+// does not reset them between requests on a kept-alive connection.
+func withDeadline(pattern string, next http.Handler) http.Handler {
+	timeout := routeTimeout(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(deadline) // not supported by every ResponseWriter (e.g. httptest)
+		rc.SetWriteDeadline(deadline)
+		next.ServeHTTP(w, r)
+	})
+}
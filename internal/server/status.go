@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/clipboard"
+	clipsync "github.com/xconnect/xconnect-go/internal/sync"
+)
+
+// Listener modes reported in Status.Listener.
+const (
+	ListenerTsnet  = "tsnet"  // embedded Tailscale node
+	ListenerSystem = "system" // system Tailscale (or plain TCP)
+	ListenerCustom = "custom" // a listener supplied by an embedding program
+)
+
+// Status is the health report served on GET /status. The handler fills in the
+// clipboard, files and approval fields; HandlerOpts.Status adds what the node knows.
+type Status struct {
+	Version       string           `json:"version"`
+	Hostname      string           `json:"hostname,omitempty"`
+	Started       time.Time        `json:"started"`
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	Listener      string           `json:"listener,omitempty"` // ListenerTsnet, ListenerSystem or ListenerCustom
+	Addr          string           `json:"addr,omitempty"`
+	Clipboard     ClipboardStatus  `json:"clipboard"`
+	Discovery     *DiscoveryStatus `json:"discovery,omitempty"` // nil without sync
+	Peers         []PeerStatus     `json:"peers,omitempty"`
+	Sync          *SyncStatus      `json:"sync,omitempty"`
+	Pending       int              `json:"pending"` // updates awaiting approval
+	Files         FilesStatus      `json:"files"`
+}
+
+// ClipboardStatus tells whether the clipboard backend works.
+type ClipboardStatus struct {
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+	Watch     string `json:"watch,omitempty"` // change detection backend (with sync)
+	Primary   bool   `json:"primary"`         // PRIMARY selection sync is on
+}
+
+// DiscoveryStatus is the state of peer discovery.
+type DiscoveryStatus struct {
+	Providers   string    `json:"providers"`
+	UpdatedAt   time.Time `json:"updated_at"` // last successful lookup
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+// PeerStatus is a discovered sync peer and when sync last reached it.
+type PeerStatus struct {
+	clipsync.PeerStats
+	URL     string `json:"url"`
+	Offline bool   `json:"offline"`
+}
+
+// SyncStatus is the sync configuration and traffic counters.
+type SyncStatus struct {
+	clipsync.ControlStatus
+	Topology     string    `json:"topology,omitempty"`
+	Transport    string    `json:"transport,omitempty"`
+	Sent         uint64    `json:"sent"`
+	Received     uint64    `json:"received"`
+	Failed       uint64    `json:"failed"`
+	LastSent     time.Time `json:"last_sent"`
+	LastReceived time.Time `json:"last_received"`
+}
+
+// FilesStatus is the disk usage of received files.
+type FilesStatus struct {
+	Dir   string `json:"dir"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+func (h *handler) getStatus(w http.ResponseWriter, r *http.Request) {
+	var st Status
+	if err := clipboard.Check(); err != nil {
+		st.Clipboard.Error = err.Error()
+	} else {
+		st.Clipboard.Available = true
+	}
+	st.Files = h.usage.get(h.storage.FileDir())
+	if o := h.opts; o != nil {
+		if o.Pending != nil {
+			st.Pending = len(o.Pending.List())
+		}
+		if o.Sync != nil {
+			st.Sync = &SyncStatus{ControlStatus: o.Sync.Status()}
+		}
+		st.Clipboard.Primary = o.ReceivePrimary != nil
+		if o.Status != nil {
+			o.Status(&st)
+		}
+	}
+	if !st.Started.IsZero() {
+		st.UptimeSeconds = int64(time.Since(st.Started).Seconds())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// filesUsageTTL is how long GET /status reuses the disk usage of the files dir, so
+// polling it does not walk the directory on every request.
+const filesUsageTTL = 30 * time.Second
+
+// usageCache holds the last filesUsage result.
+type usageCache struct {
+	mu  sync.Mutex
+	dir string
+	st  FilesStatus
+	at  time.Time
+}
+
+// get returns the usage of dir, computed at most filesUsageTTL ago.
+func (c *usageCache) get(dir string) FilesStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.at.IsZero() || c.dir != dir || time.Since(c.at) > filesUsageTTL {
+		c.dir, c.st, c.at = dir, filesUsage(dir), time.Now()
+	}
+	return c.st
+}
+
+// invalidate makes the next get recount, e.g. after a file was received.
+func (c *usageCache) invalidate() {
+	c.mu.Lock()
+	c.at = time.Time{}
+	c.mu.Unlock()
+}
+
+// filesUsage counts the files under dir and their total size; a missing dir is empty.
+func filesUsage(dir string) FilesStatus {
+	st := FilesStatus{Dir: dir}
+	if abs, err := filepath.Abs(dir); err == nil {
+		st.Dir = abs
+	}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == indexFile {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			st.Count++
+			st.Bytes += info.Size()
+		}
+		return nil
+	})
+	return st
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xconnect/xconnect-go/internal/clipboard"
+)
+
+// clipWatch wakes GET /clipboard?wait= requests when the clipboard changes. It learns
+// of changes from the handler (content written by peers) and, when fed, from the
+// caller's own clipboard watcher (see Handler.ClipboardRead); otherwise it runs one
+// clipboard.Watch while any request waits. Either way the clipboard is read once
+// per change, not once per waiting request.
+type clipWatch struct {
+	fed bool // changes are reported through set; run no watcher
+
+	mu      sync.Mutex
+	text    string
+	next    chan struct{} // closed on the next change
+	waiters int
+	stop    context.CancelFunc
+}
+
+func newClipWatch(fed bool) *clipWatch {
+	return &clipWatch{fed: fed, next: make(chan struct{})}
+}
+
+// set records text as the clipboard content, waking waiters if it changed.
+func (c *clipWatch) set(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if text == c.text {
+		return
+	}
+	c.text = text
+	close(c.next)
+	c.next = make(chan struct{})
+}
+
+// latest returns the last content seen and a channel closed on the next change.
+func (c *clipWatch) latest() (string, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.text, c.next
+}
+
+// wait registers a waiting request, starting the watcher for the first one unless
+// fed. Call release when done waiting; the watcher stops with the last waiter.
+func (c *clipWatch) wait() (release func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waiters++
+	if c.waiters == 1 && !c.fed {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.stop = cancel
+		go c.run(ctx)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.waiters--; c.waiters == 0 && c.stop != nil {
+				c.stop()
+			}
+		})
+	}
+}
+
+func (c *clipWatch) run(ctx context.Context) {
+	w := clipboard.Watch(ctx, clipboard.WatchOptions{PollInterval: clipboardWatchPoll})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.C:
+		}
+		if text, err := clipboard.ReadAll(); err == nil {
+			c.set(text)
+		}
+	}
+}
@@ -1,85 +1,185 @@
 package sync
 
 import (
-	"bytes"
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
-	"strings"
 	"time"
+
+	"github.com/xconnect/xconnect-go/internal/filter"
+	"github.com/xconnect/xconnect-go/internal/outbox"
+	"github.com/xconnect/xconnect-go/internal/protocol"
 )
 
-// ClipboardSync runs a loop that polls local clipboard and broadcasts to peers when it changes.
-// getClipboard returns current local clipboard content; getLastReceived returns the last content
-// we received from the network (so we don't re-broadcast it).
+// ClipboardSync runs a loop that checks the local clipboard and broadcasts to peers when it changes.
+// It checks on every signal from opts.Changes (see clipboard.Watch), or polls every Interval when
+// Changes is nil. Each local copy is stamped with a Version from opts.State and sent with it, so
+// receivers drop duplicates and stale updates; content that opts.State applied from a peer is
+// not a local change and is never re-broadcast. It returns when ctx is done and every peer's
+// delivery has stopped, moving undelivered updates to opts.Outbox.
 func ClipboardSync(ctx context.Context, opts Options) {
-	interval := opts.Interval
-	if interval <= 0 {
-		interval = time.Second
+	if opts.State == nil {
+		opts.State = NewState("")
+	}
+	changes := opts.Changes
+	if changes == nil {
+		interval := opts.Interval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		changes = tickChan(ctx, tick.C)
 	}
-	var lastBroadcasted string
-	tick := time.NewTicker(interval)
-	defer tick.Stop()
+	out := newFanout(ctx, &opts)
+	defer out.wait()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-tick.C:
-		}
-		current := opts.GetClipboard()
-		lastReceived := opts.GetLastReceived()
-		if current == "" {
+		case u := <-opts.Relay:
+			if !opts.Control.CanSend() {
+				continue
+			}
+			opts.Feed.Publish(u.Version, u.Content) // subscribers pull relays from the hub too
+			if opts.push() {
+				out.relay(u)
+			}
 			continue
+		case <-changes:
 		}
-		if current == lastReceived {
-			continue
+		if !settle(ctx, changes, opts.Debounce) {
+			return
 		}
-		if current == lastBroadcasted {
+		// Copies made while paused or receive-only are still recorded, so they are not
+		// sent later on resume.
+		v, current, ok := opts.State.LocalChange(opts.GetClipboard)
+		if !ok || !opts.Control.CanSend() || !opts.Filter.Allow(filter.Outgoing, "", current) {
 			continue
 		}
-		peers := opts.GetPeers()
-		if len(peers) == 0 {
+		opts.Feed.Publish(v, current)
+		localChanges.Inc()
+		if !opts.push() {
 			continue
 		}
-		ok := true
-		for _, baseURL := range peers {
-			url := strings.TrimSuffix(baseURL, "/") + "/clipboard"
-			req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader([]byte(current)))
-			if err != nil {
-				log.Printf("sync: new request: %v", err)
-				ok = false
-				continue
+		var online []Peer
+		for _, p := range opts.Topology.Targets(opts.self(), opts.GetPeers()) {
+			if !p.Offline {
+				online = append(online, p)
+			} else if opts.Outbox != nil {
+				park(opts.Outbox, p.Name, v, current)
 			}
-			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
-			if opts.GetFromHost != nil {
-				if from := opts.GetFromHost(); from != "" {
-					req.Header.Set("X-From-Host", from)
-				}
+		}
+		if len(online) == 0 {
+			continue
+		}
+		out.send(online, v, current)
+	}
+}
+
+// park stores content in ob for delivery when peer is back online.
+func park(ob *outbox.Outbox, peer string, v Version, content string) {
+	h := make(http.Header)
+	v.SetHeaders(h)
+	header := make(map[string]string, len(h))
+	for k := range h {
+		header[k] = h.Get(k)
+	}
+	if err := ob.PutClipboard(peer, content, header); err != nil {
+		slog.Error("sync: outbox", "peer", peer, "err", err)
+	}
+}
+
+func (o *Options) push() bool {
+	return o.Transport != TransportSubscribe
+}
+
+func (o *Options) self() string {
+	if o.GetFromHost == nil {
+		return ""
+	}
+	return o.GetFromHost()
+}
+
+// settle waits until no change has been signalled for d (a selection being dragged
+// changes many times); it returns false if ctx is done first.
+func settle(ctx context.Context, changes <-chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changes:
+			if !t.Stop() {
+				<-t.C
 			}
-			resp, err := opts.HTTPClient.Do(req)
-			if err != nil {
-				log.Printf("sync: POST %s: %v", url, err)
-				ok = false
-				continue
+			t.Reset(d)
+		case <-t.C:
+			return true
+		}
+	}
+}
+
+// tickChan adapts a ticker channel to the Changes signal type.
+func tickChan(ctx context.Context, c <-chan time.Time) <-chan struct{} {
+	out := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c:
 			}
-			resp.Body.Close()
-			if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-				log.Printf("sync: POST %s: %s", url, resp.Status)
-				ok = false
+			select {
+			case out <- struct{}{}:
+			case <-ctx.Done():
+				return
 			}
 		}
-		if ok {
-			lastBroadcasted = current
-		}
-	}
+	}()
+	return out
+}
+
+// Peer is a sync target.
+type Peer struct {
+	Name    string // hostname; the outbox key
+	BaseURL string
+	Offline bool // reported offline by discovery: park updates in the outbox instead of sending
 }
 
 // Options configures ClipboardSync.
 type Options struct {
-	Interval         time.Duration
-	GetClipboard     func() string
-	GetLastReceived  func() string
-	GetPeers         func() []string
-	GetFromHost      func() string // hostname to send in X-From-Host when broadcasting
-	HTTPClient       *http.Client
+	Interval     time.Duration
+	Changes      <-chan struct{} // clipboard change signals; nil = poll every Interval
+	Debounce     time.Duration   // wait for changes to settle this long before reading (0 = read at once)
+	Path         string          // peer endpoint to POST to (default "/clipboard"; "/clipboard/primary" for PRIMARY)
+	GetClipboard func() string
+	State        *State // shared with the server's ReceiveClipboard; nil = private state
+	GetPeers     func() []Peer
+	GetFromHost  func() string   // hostname to send in X-From-Host when broadcasting
+	Filter       *filter.Filter  // content that may not leave this device; nil = send everything
+	Control      *Control        // runtime pause and mode; nil = always send
+	Topology     *Topology       // whom to send to; nil = full mesh (self is GetFromHost)
+	Relay        <-chan Update   // updates the hub received, to forward (see Topology.Relays)
+	Transport    string          // TransportPush (default), TransportSubscribe or TransportBoth
+	Feed         *Feed           // local copies are published here for GET /clipboard/events; may be nil
+	Protocol     *protocol.Cache // handshakes with peers to pick /v1 paths; nil = legacy paths
+	Capability   string          // peers whose handshake lacks it are skipped (e.g. protocol.CapPrimary)
+	Stats        *Stats          // delivery counts and per-peer errors; may be nil
+	HTTPClient   *http.Client
+
+	// Per-peer delivery: each peer keeps only the newest undelivered update, retried
+	// with exponential backoff from RetryBackoff (default 1s) up to MaxBackoff (default
+	// 5m). An update older than RetryMaxAge (default 10m) is dropped.
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	RetryMaxAge  time.Duration
+
+	// Outbox, if set, keeps the latest update for offline peers and for peers whose
+	// retry queue expired, for delivery when they are back online.
+	Outbox *outbox.Outbox
 }
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"fmt"
+	gosync "sync"
+)
+
+// Sync modes.
+const (
+	ModeBoth        = "both"         // send local copies and accept peers' updates
+	ModeSendOnly    = "send-only"    // send local copies; ignore peers' updates
+	ModeReceiveOnly = "receive-only" // accept peers' updates; keep local copies here
+)
+
+// ControlStatus is the runtime state of sync, as served by GET /sync/status.
+type ControlStatus struct {
+	Enabled bool   `json:"enabled"` // auto-sync (sending) was started with -sync
+	Paused  bool   `json:"paused"`
+	Mode    string `json:"mode"`
+}
+
+// Control pauses, resumes and switches the mode of a running sync. It is shared by
+// ClipboardSync (sending) and the server's ReceiveClipboard (receiving); changes
+// take effect with the next clipboard event, without a restart.
+type Control struct {
+	enabled bool
+
+	mu     gosync.Mutex
+	paused bool
+	mode   string
+}
+
+// NewControl returns a Control in mode (ModeBoth when empty). enabled reports
+// whether sending was started at all.
+func NewControl(enabled bool, mode string) (*Control, error) {
+	c := &Control{enabled: enabled, mode: ModeBoth}
+	if mode != "" {
+		if err := c.SetMode(mode); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Pause stops sending and receiving until Resume. Updates already queued for a peer
+// are held, not dropped.
+func (c *Control) Pause() {
+	c.mu.Lock()
+	c.paused = true
+	c.mu.Unlock()
+}
+
+// Resume undoes Pause.
+func (c *Control) Resume() {
+	c.mu.Lock()
+	c.paused = false
+	c.mu.Unlock()
+}
+
+// SetMode switches between ModeBoth, ModeSendOnly and ModeReceiveOnly.
+func (c *Control) SetMode(mode string) error {
+	switch mode {
+	case ModeBoth, ModeSendOnly, ModeReceiveOnly:
+	default:
+		return fmt.Errorf("unknown sync mode %q (want %s, %s or %s)", mode, ModeBoth, ModeSendOnly, ModeReceiveOnly)
+	}
+	c.mu.Lock()
+	c.mode = mode
+	c.mu.Unlock()
+	return nil
+}
+
+// Status returns the current state.
+func (c *Control) Status() ControlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ControlStatus{Enabled: c.enabled, Paused: c.paused, Mode: c.mode}
+}
+
+// CanSend reports whether local copies may be sent. A nil Control allows everything.
+func (c *Control) CanSend() bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.paused && c.mode != ModeReceiveOnly
+}
+
+// CanReceive reports whether peers' updates may be written, and if not, why
+// ("paused" or the mode).
+func (c *Control) CanReceive() (ok bool, reason string) {
+	if c == nil {
+		return true, ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.paused:
+		return false, "paused"
+	case c.mode == ModeSendOnly:
+		return false, ModeSendOnly
+	}
+	return true, ""
+}
@@ -0,0 +1,350 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/protocol"
+)
+
+const (
+	defaultRetryBackoff = time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+	defaultRetryMaxAge  = 10 * time.Minute
+	pausedRecheck       = time.Second // how often a paused queue checks for Resume
+)
+
+// fanout delivers clipboard updates to each peer from its own goroutine, so a slow or
+// dead peer never delays the others.
+type fanout struct {
+	ctx   context.Context
+	opts  *Options
+	mu    gosync.Mutex
+	peers map[string]*peerQueue // base URL -> queue
+	wg    gosync.WaitGroup
+}
+
+func newFanout(ctx context.Context, opts *Options) *fanout {
+	return &fanout{ctx: ctx, opts: opts, peers: make(map[string]*peerQueue)}
+}
+
+// send queues content (at version v) for every peer.
+func (f *fanout) send(peers []Peer, v Version, content string) {
+	f.reap()
+	for _, p := range peers {
+		f.peer(p).enqueue(v, content)
+	}
+}
+
+// relay forwards an update received by the hub to every online peer but its sender.
+func (f *fanout) relay(u Update) {
+	var peers []Peer
+	for _, p := range f.opts.GetPeers() {
+		if !p.Offline && !SameHost(p.Name, u.From) {
+			peers = append(peers, p)
+		}
+	}
+	f.send(peers, u.Version, u.Content)
+}
+
+func (f *fanout) peer(p Peer) *peerQueue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, ok := f.peers[p.BaseURL]
+	if !ok {
+		ctx, cancel := context.WithCancel(f.ctx)
+		q = &peerQueue{name: p.Name, url: strings.TrimSuffix(p.BaseURL, "/"), opts: f.opts, wake: make(chan struct{}, 1), cancel: cancel}
+		f.peers[p.BaseURL] = q
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			q.run(ctx)
+		}()
+	}
+	return q
+}
+
+// reap stops the idle queues of peers that discovery no longer reports. A queue
+// still holding an update is kept until it is delivered or expires to the outbox.
+func (f *fanout) reap() {
+	known := map[string]bool{}
+	for _, p := range f.opts.GetPeers() {
+		known[p.BaseURL] = true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for url, q := range f.peers {
+		if !known[url] && q.idle() {
+			q.cancel()
+			delete(f.peers, url)
+		}
+	}
+}
+
+// wait waits for the peer goroutines to stop after ctx is done.
+func (f *fanout) wait() { f.wg.Wait() }
+
+type queuedItem struct {
+	version Version
+	content string
+	queued  time.Time
+}
+
+// peerQueue holds the pending update for one peer and retries with exponential
+// backoff. The clipboard is last-writer-wins, so only the newest update is kept: a
+// peer that comes back gets the latest content, not every version it missed.
+type peerQueue struct {
+	name   string
+	url    string
+	opts   *Options
+	wake   chan struct{}
+	cancel context.CancelFunc
+
+	mu            gosync.Mutex
+	pending       *queuedItem
+	lastDelivered string // hash of the last content the peer acknowledged
+	failures      int
+	retryAt       time.Time
+}
+
+func (q *peerQueue) enqueue(v Version, content string) {
+	q.mu.Lock()
+	if v.Hash == q.lastDelivered && q.pending == nil {
+		q.mu.Unlock()
+		return
+	}
+	if q.pending != nil {
+		slog.Debug("sync: replacing undelivered update", "peer", q.url)
+	}
+	q.pending = &queuedItem{version: v, content: content, queued: time.Now()}
+	q.mu.Unlock()
+	q.kick()
+}
+
+func (q *peerQueue) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending == nil
+}
+
+func (q *peerQueue) kick() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *peerQueue) run(ctx context.Context) {
+	var timer *time.Timer
+	for {
+		q.mu.Lock()
+		q.expire()
+		idle := q.pending == nil
+		wait := time.Until(q.retryAt)
+		q.mu.Unlock()
+
+		if idle {
+			select {
+			case <-ctx.Done():
+				q.flush()
+				return
+			case <-q.wake:
+			}
+			continue
+		}
+		if wait > 0 {
+			if timer == nil {
+				timer = time.NewTimer(wait)
+			} else {
+				timer.Reset(wait)
+			}
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				q.flush()
+				return
+			case <-timer.C:
+			}
+		}
+		q.deliverNext(ctx)
+	}
+}
+
+// flush moves the undelivered update to the outbox (if any) when sync stops, so it
+// is delivered after a restart.
+func (q *peerQueue) flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil || q.opts.Outbox == nil || q.name == "" {
+		return
+	}
+	item := q.pending
+	q.pending = nil
+	slog.Info("sync: moving undelivered update to the outbox on shutdown", "peer", q.url)
+	park(q.opts.Outbox, q.name, item.version, item.content)
+}
+
+// expire drops the pending update once it is older than RetryMaxAge, moving it to
+// the outbox (if any). q.mu must be held.
+func (q *peerQueue) expire() {
+	maxAge := q.opts.RetryMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultRetryMaxAge
+	}
+	if q.pending == nil || time.Since(q.pending.queued) <= maxAge {
+		return
+	}
+	item := q.pending
+	q.pending = nil
+	if q.opts.Outbox != nil && q.name != "" {
+		slog.Info("sync: moving undelivered update to the outbox", "peer", q.url, "after", maxAge)
+		park(q.opts.Outbox, q.name, item.version, item.content)
+		return
+	}
+	slog.Warn("sync: dropping undelivered update", "peer", q.url, "older_than", maxAge)
+}
+
+func (q *peerQueue) deliverNext(ctx context.Context) {
+	q.mu.Lock()
+	item := q.pending
+	if item != nil && !q.opts.Control.CanSend() {
+		// Paused or receive-only: hold the update until sending is allowed again.
+		q.retryAt = time.Now().Add(pausedRecheck)
+		item = nil
+	}
+	q.mu.Unlock()
+	if item == nil {
+		return
+	}
+
+	err := q.post(ctx, item.version, item.content)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		// The peer will not take this update however often we retry (e.g. 404 from a
+		// peer without PRIMARY sync); drop it.
+		slog.Warn("sync: update rejected, dropped", "peer", q.url, "err", err)
+		q.opts.Stats.Failed(q.peerName(), err)
+		syncFailures.Inc(q.peerName())
+		q.failures = 0
+		q.retryAt = time.Time{}
+		if q.pending == item {
+			q.pending = nil
+		}
+		return
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		q.opts.Stats.Failed(q.peerName(), err)
+		syncFailures.Inc(q.peerName())
+		q.failures++
+		backoff := q.backoff()
+		q.retryAt = time.Now().Add(backoff)
+		slog.Warn("sync: delivery failed", "peer", q.url, "err", err, "retry_in", backoff)
+		return
+	}
+	if q.failures > 0 {
+		slog.Info("sync: delivered after failed attempts", "peer", q.url, "failures", q.failures)
+	}
+	q.opts.Stats.Sent(q.peerName())
+	syncBroadcasts.Inc(q.peerName())
+	ClipboardBytes.Add(float64(len(item.content)), "sent")
+	q.failures = 0
+	q.retryAt = time.Time{}
+	q.lastDelivered = item.version.Hash
+	if q.pending == item {
+		q.pending = nil
+	}
+}
+
+// peerName names the peer in Stats: its hostname, or its URL when unnamed.
+func (q *peerQueue) peerName() string {
+	if q.name != "" {
+		return q.name
+	}
+	return q.url
+}
+
+// backoff returns RetryBackoff * 2^(failures-1), capped at MaxBackoff; q.mu must be held.
+func (q *peerQueue) backoff() time.Duration {
+	base, max := q.opts.RetryBackoff, q.opts.MaxBackoff
+	if base <= 0 {
+		base = defaultRetryBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	d := base
+	for i := 1; i < q.failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func (q *peerQueue) post(ctx context.Context, v Version, content string) error {
+	path := q.opts.Path
+	if path == "" {
+		path = "/clipboard"
+	}
+	if q.opts.Protocol != nil {
+		hello, err := q.opts.Protocol.Get(ctx, q.url)
+		if protocol.IsIncompatible(err) {
+			return &rejectedError{err}
+		} else if err != nil {
+			return fmt.Errorf("%s: handshake: %w", q.url, err)
+		}
+		if c := q.opts.Capability; c != "" && !hello.Has(c) {
+			return &rejectedError{fmt.Errorf("%s: peer does not support %s", q.url, c)}
+		}
+		path = hello.Path(path)
+	}
+	url := q.url + path
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader([]byte(content)))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	protocol.SetHeader(req)
+	v.SetHeaders(req.Header)
+	if q.opts.GetFromHost != nil {
+		if from := q.opts.GetFromHost(); from != "" {
+			req.Header.Set("X-From-Host", from)
+		}
+	}
+	resp, err := q.opts.HTTPClient.Do(req)
+	if err != nil {
+		q.opts.Protocol.Forget(q.url) // the peer may come back upgraded
+		return fmt.Errorf("POST %s: %w", url, err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusUpgradeRequired:
+		q.opts.Protocol.Forget(q.url)
+		return &rejectedError{fmt.Errorf("POST %s: %s (peer needs a newer xconnect)", url, resp.Status)}
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return &rejectedError{fmt.Errorf("POST %s: %s", url, resp.Status)}
+	}
+	return fmt.Errorf("POST %s: %s", url, resp.Status)
+}
+
+// rejectedError is a client error response that retrying will not fix.
+type rejectedError struct{ error }
+
+func (e *rejectedError) Unwrap() error { return e.error }
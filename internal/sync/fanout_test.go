@@ -0,0 +1,232 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/outbox"
+)
+
+// scriptedPeer answers POSTs with codes in turn, repeating the last one, and records
+// the bodies of the requests it accepted.
+type scriptedPeer struct {
+	*httptest.Server
+	mu       gosync.Mutex
+	codes    []int
+	requests int
+	received []string
+	headers  []http.Header
+}
+
+func newScriptedPeer(t *testing.T, codes ...int) *scriptedPeer {
+	p := &scriptedPeer{codes: codes}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		p.mu.Lock()
+		code := p.codes[min(p.requests, len(p.codes)-1)]
+		p.requests++
+		if code < 300 {
+			p.received = append(p.received, string(b))
+			p.headers = append(p.headers, r.Header)
+		}
+		p.mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *scriptedPeer) count() (requests int, received []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests, append([]string(nil), p.received...)
+}
+
+// eventually polls cond for up to 5s.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testOptions(p *scriptedPeer) (*Options, []Peer) {
+	peers := []Peer{{Name: "laptop", BaseURL: p.URL + "/"}}
+	return &Options{
+		HTTPClient:   p.Client(),
+		GetPeers:     func() []Peer { return peers },
+		GetFromHost:  func() string { return "desk" },
+		Stats:        &Stats{},
+		RetryBackoff: 10 * time.Millisecond,
+	}, peers
+}
+
+func version(content string) Version {
+	return Version{Origin: "desk", Clock: uint64(time.Now().UnixMilli()), Hash: Hash(content)}
+}
+
+func TestFanoutRetries(t *testing.T) {
+	p := newScriptedPeer(t, http.StatusInternalServerError, http.StatusNoContent)
+	opts, peers := testOptions(p)
+	ctx, cancel := context.WithCancel(context.Background())
+	f := newFanout(ctx, opts)
+	defer func() { cancel(); f.wait() }()
+
+	v := version("hello")
+	f.send(peers, v, "hello")
+	eventually(t, "delivery after a 500", func() bool { _, got := p.count(); return len(got) == 1 })
+
+	requests, got := p.count()
+	if requests != 2 || got[0] != "hello" {
+		t.Errorf("peer saw %d requests, accepted %q; want 2, hello", requests, got)
+	}
+	p.mu.Lock()
+	h := p.headers[0]
+	p.mu.Unlock()
+	if gv, ok := VersionFromHeaders(h); !ok || gv != v || h.Get("X-From-Host") != "desk" {
+		t.Errorf("headers = %v, want version %+v from desk", h, v)
+	}
+	if s := opts.Stats.Snapshot(); s.Sent != 1 || s.Failed != 1 {
+		t.Errorf("Stats = %+v, want 1 sent, 1 failed", s)
+	}
+
+	// Content the peer already has is not sent again.
+	f.send(peers, v, "hello")
+	time.Sleep(50 * time.Millisecond)
+	if requests, _ := p.count(); requests != 2 {
+		t.Errorf("resent delivered content: %d requests", requests)
+	}
+}
+
+func TestFanoutRejected(t *testing.T) {
+	for _, code := range []int{http.StatusForbidden, http.StatusNotFound, http.StatusUpgradeRequired} {
+		p := newScriptedPeer(t, code, http.StatusNoContent)
+		opts, peers := testOptions(p)
+		ctx, cancel := context.WithCancel(context.Background())
+		f := newFanout(ctx, opts)
+
+		f.send(peers, version("secret"), "secret")
+		eventually(t, "the rejected request", func() bool { n, _ := p.count(); return n == 1 })
+		q := f.peer(peers[0])
+		eventually(t, "the update to be dropped", q.idle)
+		time.Sleep(50 * time.Millisecond)
+		if n, _ := p.count(); n != 1 {
+			t.Errorf("%d: retried a rejected update (%d requests)", code, n)
+		}
+		if s := opts.Stats.Snapshot(); s.Failed != 1 || s.Sent != 0 {
+			t.Errorf("%d: Stats = %+v", code, s)
+		}
+		cancel()
+		f.wait()
+	}
+}
+
+func TestQueueKeepsNewest(t *testing.T) {
+	q := &peerQueue{opts: &Options{}, wake: make(chan struct{}, 1)}
+	q.enqueue(version("one"), "one")
+	q.enqueue(version("two"), "two")
+	if q.pending == nil || q.pending.content != "two" {
+		t.Errorf("pending = %+v, want two", q.pending)
+	}
+
+	q.pending = nil
+	q.lastDelivered = Hash("two")
+	q.enqueue(version("two"), "two")
+	if q.pending != nil {
+		t.Error("queued content the peer already has")
+	}
+	// Going back to delivered content after something newer is queued still replaces it.
+	q.enqueue(version("three"), "three")
+	q.enqueue(version("two"), "two")
+	if q.pending == nil || q.pending.content != "two" {
+		t.Errorf("pending = %+v, want two", q.pending)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := &peerQueue{opts: &Options{RetryBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		q.failures = i + 1
+		if got := q.backoff(); got != want {
+			t.Errorf("backoff after %d failures = %v, want %v", q.failures, got, want)
+		}
+	}
+	q = &peerQueue{opts: &Options{}, failures: 100}
+	if got := q.backoff(); got != defaultMaxBackoff {
+		t.Errorf("default backoff after 100 failures = %v, want %v", got, defaultMaxBackoff)
+	}
+	q.failures = 1
+	if got := q.backoff(); got != defaultRetryBackoff {
+		t.Errorf("default first backoff = %v, want %v", got, defaultRetryBackoff)
+	}
+}
+
+func TestFanoutParksUndelivered(t *testing.T) {
+	ob, err := outbox.Open(filepath.Join(t.TempDir(), "outbox"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newScriptedPeer(t, http.StatusServiceUnavailable)
+	opts, peers := testOptions(p)
+	opts.RetryBackoff = time.Hour
+	opts.Outbox = ob
+	ctx, cancel := context.WithCancel(context.Background())
+	f := newFanout(ctx, opts)
+
+	v := version("pending")
+	f.send(peers, v, "pending")
+	eventually(t, "the failed attempt", func() bool { return opts.Stats.Snapshot().Failed == 1 })
+	cancel()
+	f.wait()
+
+	items, err := ob.Pending("laptop")
+	if err != nil || len(items) != 1 || items[0].Text != "pending" {
+		t.Fatalf("outbox = %+v, %v; want the undelivered update", items, err)
+	}
+	h := make(http.Header)
+	for k, val := range items[0].Header {
+		h.Set(k, val)
+	}
+	if gv, ok := VersionFromHeaders(h); !ok || gv != v {
+		t.Errorf("parked version = %+v, want %+v", gv, v)
+	}
+
+	// An update that outlives RetryMaxAge goes to the outbox too.
+	ob.Remove(items[0])
+	q := &peerQueue{name: "laptop", url: p.URL, opts: &Options{RetryMaxAge: time.Millisecond, Outbox: ob}}
+	q.pending = &queuedItem{version: v, content: "old", queued: time.Now().Add(-time.Second)}
+	q.expire()
+	if items, _ := ob.Pending("laptop"); q.pending != nil || len(items) != 1 || items[0].Text != "old" {
+		t.Errorf("after expire: pending %+v, outbox %+v", q.pending, items)
+	}
+}
+
+func TestFanoutReap(t *testing.T) {
+	p := newScriptedPeer(t, http.StatusNoContent)
+	opts, peers := testOptions(p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFanout(ctx, opts)
+
+	f.send(peers, version("x"), "x")
+	eventually(t, "delivery", func() bool { _, got := p.count(); return len(got) == 1 })
+	eventually(t, "an idle queue", f.peer(peers[0]).idle)
+	opts.GetPeers = func() []Peer { return nil }
+	f.reap()
+	f.mu.Lock()
+	n := len(f.peers)
+	f.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d queues left for departed peers", n)
+	}
+	f.wait()
+}
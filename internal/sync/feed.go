@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"encoding/json"
+	gosync "sync"
+)
+
+// Event is one clipboard update on a Feed, as sent on GET /clipboard/events.
+type Event struct {
+	Origin  string `json:"origin"`
+	Clock   uint64 `json:"clock"`
+	Hash    string `json:"hash"`
+	Content string `json:"content"`
+}
+
+// Version returns the event's version.
+func (e Event) Version() Version {
+	return Version{Origin: e.Origin, Clock: e.Clock, Hash: e.Hash}
+}
+
+// ID is the event ID (and ETag) used to resume a stream: the content hash.
+func (e Event) ID() string { return e.Hash }
+
+// Data returns the event's JSON encoding.
+func (e Event) Data() []byte {
+	b, _ := json.Marshal(e)
+	return b
+}
+
+// Feed publishes this node's local copies (after filters, pause and mode) to
+// subscribers. Only the latest event is kept: clipboard content is latest-wins, so
+// a subscriber that resumes only needs the current one.
+type Feed struct {
+	mu      gosync.Mutex
+	cur     Event
+	changed chan struct{}
+}
+
+// NewFeed returns an empty Feed.
+func NewFeed() *Feed {
+	return &Feed{changed: make(chan struct{})}
+}
+
+// Publish makes content at version v the current event and wakes all waiters.
+func (f *Feed) Publish(v Version, content string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.cur = Event{Origin: v.Origin, Clock: v.Clock, Hash: v.Hash, Content: content}
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.mu.Unlock()
+}
+
+// Current returns the current event (zero before the first Publish) and a channel
+// that is closed on the next Publish.
+func (f *Feed) Current() (Event, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cur, f.changed
+}
@@ -0,0 +1,13 @@
+package sync
+
+import "github.com/xconnect/xconnect-go/internal/metrics"
+
+var (
+	// ClipboardBytes counts clipboard content leaving this node (sent: pushed to peers,
+	// pulled or streamed) and arriving from peers (received).
+	ClipboardBytes = metrics.NewCounter("xconnect_clipboard_bytes_total", "Clipboard content bytes sent to and received from peers.", "direction")
+
+	syncBroadcasts = metrics.NewCounter("xconnect_sync_broadcasts_total", "Clipboard updates delivered to each peer.", "peer")
+	syncFailures   = metrics.NewCounter("xconnect_sync_failures_total", "Failed deliveries to (each retry counts) and subscriptions with each peer.", "peer")
+	localChanges   = metrics.NewCounter("xconnect_sync_local_changes_total", "Local clipboard copies published to peers.")
+)
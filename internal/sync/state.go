@@ -0,0 +1,182 @@
+package sync
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	gosync "sync"
+	"time"
+)
+
+// Headers carrying the version of a clipboard update between peers.
+const (
+	HeaderOrigin = "X-XConnect-Origin" // node ID of the device where the content was copied
+	HeaderClock  = "X-XConnect-Clock"  // logical timestamp of the copy
+	HeaderHash   = "X-XConnect-Hash"   // hex SHA-256 of the content
+)
+
+// MaxClockSkew is how far ahead of our own clock (in milliseconds, as clocks are
+// seeded from wall-clock time) a peer's clock may be. Updates further ahead are
+// rejected: taking such a clock would make every later local copy look stale.
+const MaxClockSkew = uint64(24 * time.Hour / time.Millisecond)
+
+// Version identifies one clipboard update. Versions are totally ordered by (Clock, Origin).
+type Version struct {
+	Origin string
+	Clock  uint64
+	Hash   string
+}
+
+// Less reports whether v is older than o.
+func (v Version) Less(o Version) bool {
+	if v.Clock != o.Clock {
+		return v.Clock < o.Clock
+	}
+	return v.Origin < o.Origin
+}
+
+// SetHeaders writes v to h.
+func (v Version) SetHeaders(h http.Header) {
+	h.Set(HeaderOrigin, v.Origin)
+	h.Set(HeaderClock, strconv.FormatUint(v.Clock, 10))
+	h.Set(HeaderHash, v.Hash)
+}
+
+// VersionFromHeaders reads a version from h; ok is false if the sender did not send one
+// (older releases, CLI push).
+func VersionFromHeaders(h http.Header) (v Version, ok bool) {
+	v.Origin = h.Get(HeaderOrigin)
+	clock, err := strconv.ParseUint(h.Get(HeaderClock), 10, 64)
+	if v.Origin == "" || err != nil {
+		return Version{}, false
+	}
+	v.Clock = clock
+	v.Hash = h.Get(HeaderHash)
+	return v, true
+}
+
+// Hash returns the hex SHA-256 of content.
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewNodeID returns a random node ID.
+func NewNodeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// State is this node's view of the newest clipboard content in the mesh. It keeps a
+// Lamport clock, seeded from wall-clock milliseconds so that a restarted node does not
+// start behind its peers, and the version of the content currently on the clipboard.
+// Local copies get a fresh version; updates from peers are applied only when newer.
+// Reads of the local clipboard (LocalChange) and writes of peer content (Apply) are
+// serialized, so a peer write can never be mistaken for a local copy.
+type State struct {
+	NodeID string
+
+	clipMu gosync.Mutex // held across clipboard read/compare and write/record
+	mu     gosync.Mutex
+	clock  uint64
+	cur    Version
+}
+
+// NewState returns a State for nodeID (NewNodeID when empty).
+func NewState(nodeID string) *State {
+	if nodeID == "" {
+		nodeID = NewNodeID()
+	}
+	return &State{NodeID: nodeID}
+}
+
+// Current returns the version of the content last copied or applied.
+func (s *State) Current() Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+// tick advances the clock for a local event; s.mu must be held.
+func (s *State) tick() uint64 {
+	s.clock++
+	if now := uint64(time.Now().UnixMilli()); now > s.clock {
+		s.clock = now
+	}
+	return s.clock
+}
+
+// LocalChange reads the clipboard with read and, if its content differs from the
+// current version, records it as a new local copy. It returns the new version and the
+// content; ok is false for empty content or content we already have.
+func (s *State) LocalChange(read func() string) (v Version, content string, ok bool) {
+	s.clipMu.Lock()
+	defer s.clipMu.Unlock()
+	content = read()
+	if content == "" {
+		return Version{}, "", false
+	}
+	h := Hash(content)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h == s.cur.Hash {
+		return Version{}, "", false
+	}
+	s.cur = Version{Origin: s.NodeID, Clock: s.tick(), Hash: h}
+	return s.cur, content, true
+}
+
+// Apply writes content received from a peer with write, if v is newer than what we
+// have and is not a duplicate. It returns whether the content was written and, when
+// not, why ("duplicate", "stale" or "future" for a clock more than MaxClockSkew
+// ahead of ours). The hash in v is ignored and recomputed from content.
+func (s *State) Apply(v Version, content string, write func(string) error) (applied bool, reason string, err error) {
+	s.clipMu.Lock()
+	defer s.clipMu.Unlock()
+	v.Hash = Hash(content)
+	s.mu.Lock()
+	if limit := max(s.clock, uint64(time.Now().UnixMilli())) + MaxClockSkew; v.Clock > limit {
+		s.mu.Unlock()
+		return false, "future", nil
+	}
+	if v.Clock > s.clock {
+		s.clock = v.Clock
+	}
+	switch {
+	case v.Hash == s.cur.Hash:
+		if s.cur.Less(v) {
+			s.cur = v
+		}
+		s.mu.Unlock()
+		return false, "duplicate", nil
+	case v.Less(s.cur):
+		s.mu.Unlock()
+		return false, "stale", nil
+	}
+	s.mu.Unlock()
+	if err := write(content); err != nil {
+		return false, "", err
+	}
+	s.mu.Lock()
+	s.cur = v
+	s.mu.Unlock()
+	return true, "", nil
+}
+
+// ApplyUnversioned applies content from a sender that does not send versions (e.g. a
+// manual `xconnect push`): it always wins and gets a fresh version from our clock,
+// which is returned.
+func (s *State) ApplyUnversioned(origin, content string, write func(string) error) (Version, error) {
+	s.clipMu.Lock()
+	defer s.clipMu.Unlock()
+	if err := write(content); err != nil {
+		return Version{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur = Version{Origin: origin, Clock: s.tick(), Hash: Hash(content)}
+	return s.cur, nil
+}
@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVersionLess(t *testing.T) {
+	for _, tt := range []struct {
+		v, o Version
+		want bool
+	}{
+		{Version{Origin: "b", Clock: 1}, Version{Origin: "a", Clock: 2}, true},
+		{Version{Origin: "a", Clock: 2}, Version{Origin: "b", Clock: 1}, false},
+		{Version{Origin: "a", Clock: 5}, Version{Origin: "b", Clock: 5}, true}, // tie: origin decides
+		{Version{Origin: "b", Clock: 5}, Version{Origin: "a", Clock: 5}, false},
+		{Version{Origin: "a", Clock: 5}, Version{Origin: "a", Clock: 5}, false},
+	} {
+		if got := tt.v.Less(tt.o); got != tt.want {
+			t.Errorf("%+v.Less(%+v) = %v, want %v", tt.v, tt.o, got, tt.want)
+		}
+	}
+}
+
+func TestVersionHeaders(t *testing.T) {
+	v := Version{Origin: "n1", Clock: 42, Hash: Hash("x")}
+	h := make(http.Header)
+	v.SetHeaders(h)
+	if got, ok := VersionFromHeaders(h); !ok || got != v {
+		t.Errorf("VersionFromHeaders = %+v, %v; want %+v", got, ok, v)
+	}
+	h.Set(HeaderClock, "soon")
+	if _, ok := VersionFromHeaders(h); ok {
+		t.Error("VersionFromHeaders accepted a bad clock")
+	}
+	if _, ok := VersionFromHeaders(http.Header{}); ok {
+		t.Error("VersionFromHeaders accepted a request without a version")
+	}
+}
+
+// TestApply applies updates in order to one State; each step sees the ones before.
+func TestApply(t *testing.T) {
+	s := NewState("local")
+	var clip string
+	write := func(c string) error { clip = c; return nil }
+	now := uint64(time.Now().UnixMilli())
+
+	for _, tt := range []struct {
+		name    string
+		v       Version
+		content string
+		applied bool
+		reason  string
+		clip    string // clipboard after the step
+	}{
+		{"first update", Version{Origin: "b", Clock: now + 1000}, "one", true, "", "one"},
+		{"same clock, lower origin", Version{Origin: "a", Clock: now + 1000}, "two", false, "stale", "one"},
+		{"same clock, higher origin", Version{Origin: "c", Clock: now + 1000}, "three", true, "", "three"},
+		{"same content, newer version", Version{Origin: "d", Clock: now + 1000}, "three", false, "duplicate", "three"},
+		{"older clock", Version{Origin: "z", Clock: now + 999}, "four", false, "stale", "three"},
+		{"lying hash", Version{Origin: "e", Clock: now + 2000, Hash: Hash("three")}, "five", true, "", "five"},
+		{"within clock skew", Version{Origin: "f", Clock: now + MaxClockSkew - 60000}, "six", true, "", "six"},
+		{"beyond clock skew", Version{Origin: "g", Clock: now + 3*MaxClockSkew}, "seven", false, "future", "six"},
+	} {
+		applied, reason, err := s.Apply(tt.v, tt.content, write)
+		if err != nil || applied != tt.applied || reason != tt.reason || clip != tt.clip {
+			t.Errorf("%s: Apply = %v, %q, %v; clipboard %q; want %v, %q, clipboard %q",
+				tt.name, applied, reason, err, clip, tt.applied, tt.reason, tt.clip)
+		}
+	}
+	if cur := s.Current(); cur.Origin != "f" || cur.Hash != Hash("six") {
+		t.Errorf("Current = %+v, want f's version of six", cur)
+	}
+
+	// A failed write changes nothing.
+	fail := errors.New("clipboard busy")
+	if applied, _, err := s.Apply(Version{Origin: "h", Clock: now + MaxClockSkew}, "eight", func(string) error { return fail }); applied || err != fail {
+		t.Errorf("Apply with a failing write = %v, %v", applied, err)
+	}
+	if cur := s.Current(); cur.Origin != "f" {
+		t.Errorf("after a failed write: Current = %+v", cur)
+	}
+}
+
+func TestLocalChange(t *testing.T) {
+	s := NewState("local")
+	clip := ""
+	read := func() string { return clip }
+
+	if _, _, ok := s.LocalChange(read); ok {
+		t.Error("LocalChange reported an empty clipboard")
+	}
+	clip = "copied"
+	v, content, ok := s.LocalChange(read)
+	if !ok || content != "copied" || v.Origin != "local" || v.Hash != Hash("copied") {
+		t.Fatalf("LocalChange = %+v, %q, %v", v, content, ok)
+	}
+	if _, _, ok := s.LocalChange(read); ok {
+		t.Error("LocalChange reported the same content twice")
+	}
+
+	// The clock merges with peers': a local copy after a peer's update is newer than it.
+	peer := Version{Origin: "peer", Clock: v.Clock + 100000}
+	s.Apply(peer, "from peer", func(c string) error { clip = c; return nil })
+	if _, _, ok := s.LocalChange(read); ok {
+		t.Error("content applied from a peer was taken for a local copy")
+	}
+	clip = "copied again"
+	v2, _, ok := s.LocalChange(read)
+	if !ok || !peer.Less(v2) {
+		t.Errorf("LocalChange after a peer update = %+v, want newer than %+v", v2, peer)
+	}
+}
+
+func TestApplyUnversioned(t *testing.T) {
+	s := NewState("local")
+	now := uint64(time.Now().UnixMilli())
+	s.Apply(Version{Origin: "peer", Clock: now + 5000}, "versioned", func(string) error { return nil })
+
+	var clip string
+	v, err := s.ApplyUnversioned("cli", "pushed", func(c string) error { clip = c; return nil })
+	if err != nil || clip != "pushed" || v.Origin != "cli" || v.Clock <= now+5000 || v.Hash != Hash("pushed") {
+		t.Errorf("ApplyUnversioned = %+v, %v; clipboard %q", v, err, clip)
+	}
+	if s.Current() != v {
+		t.Errorf("Current = %+v, want %+v", s.Current(), v)
+	}
+}
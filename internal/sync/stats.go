@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"sort"
+	gosync "sync"
+	"time"
+)
+
+// PeerStats is what sync last saw of one peer.
+type PeerStats struct {
+	Name        string    `json:"name"`
+	LastContact time.Time `json:"last_contact"` // last successful delivery, event or update from the peer
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+// StatsSnapshot is a copy of Stats, as served by GET /status.
+type StatsSnapshot struct {
+	Sent         uint64      `json:"sent"`     // updates delivered to peers
+	Received     uint64      `json:"received"` // updates from peers written to the clipboard
+	Failed       uint64      `json:"failed"`   // failed deliveries (each retry counts)
+	LastSent     time.Time   `json:"last_sent"`
+	LastReceived time.Time   `json:"last_received"`
+	Peers        []PeerStats `json:"peers,omitempty"`
+}
+
+// Stats counts sync traffic and remembers the last contact and error per peer. The
+// zero value is ready to use, and a nil *Stats records nothing.
+type Stats struct {
+	mu    gosync.Mutex
+	snap  StatsSnapshot
+	peers map[string]*PeerStats // by hostname; looked up with SameHost
+}
+
+// peer returns the entry for name, creating it; s.mu must be held.
+func (s *Stats) peer(name string) *PeerStats {
+	if s.peers == nil {
+		s.peers = make(map[string]*PeerStats)
+	}
+	if p, ok := s.peers[name]; ok {
+		return p
+	}
+	for k, p := range s.peers {
+		if SameHost(k, name) {
+			return p
+		}
+	}
+	p := &PeerStats{Name: name}
+	s.peers[name] = p
+	return p
+}
+
+// Sent records an update delivered to peer.
+func (s *Stats) Sent(peer string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.snap.Sent++
+	s.snap.LastSent = now
+	s.peer(peer).LastContact = now
+}
+
+// Received records an update from peer written to the clipboard.
+func (s *Stats) Received(peer string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.snap.Received++
+	s.snap.LastReceived = now
+	s.peer(peer).LastContact = now
+}
+
+// Contact records that peer answered (e.g. sent an event on its stream).
+func (s *Stats) Contact(peer string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peer(peer).LastContact = time.Now()
+}
+
+// Failed records a failed delivery to or subscription with peer.
+func (s *Stats) Failed(peer string, err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snap.Failed++
+	p := s.peer(peer)
+	p.LastError = err.Error()
+	p.LastErrorAt = time.Now()
+}
+
+// Peer returns what is known about peer (zero times if nothing).
+func (s *Stats) Peer(peer string) PeerStats {
+	if s == nil {
+		return PeerStats{Name: peer}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, p := range s.peers {
+		if SameHost(k, peer) {
+			return *p
+		}
+	}
+	return PeerStats{Name: peer}
+}
+
+// Snapshot returns the counters and every peer seen so far, sorted by name.
+func (s *Stats) Snapshot() StatsSnapshot {
+	if s == nil {
+		return StatsSnapshot{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := s.snap
+	snap.Peers = nil
+	for _, p := range s.peers {
+		snap.Peers = append(snap.Peers, *p)
+	}
+	sort.Slice(snap.Peers, func(i, j int) bool { return snap.Peers[i].Name < snap.Peers[j].Name })
+	return snap
+}
@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	gosync "sync"
+	"time"
+
+	"github.com/xconnect/xconnect-go/pkg/client"
+)
+
+// Transports.
+const (
+	TransportPush      = "push"      // POST each local copy to the peers
+	TransportSubscribe = "subscribe" // only publish local copies; peers pull them over SSE
+	TransportBoth      = "both"      // push and subscribe to the peers' streams
+)
+
+// Subscribes reports whether transport includes subscribing to peers.
+func Subscribes(transport string) bool {
+	return transport == TransportSubscribe || transport == TransportBoth
+}
+
+// SubscribeOptions configures Subscribe.
+type SubscribeOptions struct {
+	GetPeers func() []Peer
+	// HTTPClient must not have a Timeout, since streams stay open (default: a client
+	// with only a response-header timeout).
+	HTTPClient *http.Client
+	// Interval between checks of the peer list (default 30s).
+	Interval time.Duration
+	// GetFromHost returns our hostname for the X-From-Host header (optional).
+	GetFromHost func() string
+	// Apply is called for every event from peer p.
+	Apply func(p Peer, ev Event)
+	// Stats records contact with and errors from each stream; may be nil.
+	Stats *Stats
+}
+
+// Subscribe keeps one SSE subscription (GET /v1/clipboard/events) per online peer
+// until ctx is done, resuming each stream with Last-Event-ID after a disconnect, so
+// nodes that cannot be reached inbound still receive their peers' copies.
+func Subscribe(ctx context.Context, opts SubscribeOptions) {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 15 * time.Second,
+		}}
+	}
+	subs := make(map[string]context.CancelFunc) // base URL -> subscription
+	var wg gosync.WaitGroup
+	defer wg.Wait()
+	tick := time.NewTicker(opts.Interval)
+	defer tick.Stop()
+	for {
+		want := make(map[string]Peer)
+		for _, p := range opts.GetPeers() {
+			if !p.Offline {
+				want[p.BaseURL] = p
+			}
+		}
+		for u, cancel := range subs {
+			if _, ok := want[u]; !ok {
+				cancel()
+				delete(subs, u)
+			}
+		}
+		for u, p := range want {
+			if _, ok := subs[u]; ok {
+				continue
+			}
+			sctx, cancel := context.WithCancel(ctx)
+			subs[u] = cancel
+			wg.Add(1)
+			go func(p Peer) {
+				defer wg.Done()
+				subscribePeer(sctx, &opts, p)
+			}(p)
+		}
+		select {
+		case <-ctx.Done():
+			for _, cancel := range subs {
+				cancel()
+			}
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// subscribePeer reads p's stream, reconnecting with exponential backoff (1s to 1m).
+func subscribePeer(ctx context.Context, opts *SubscribeOptions, p Peer) {
+	c := &client.Client{BaseURL: p.BaseURL, HTTPClient: opts.HTTPClient}
+	var lastID string
+	backoff := time.Second
+	for {
+		if opts.GetFromHost != nil {
+			c.FromHost = opts.GetFromHost()
+		}
+		start := time.Now()
+		err := c.Events(ctx, lastID, func(ev client.Event) error {
+			opts.Stats.Contact(p.Name)
+			opts.Apply(p, Event{Origin: ev.Origin, Clock: ev.Clock, Hash: ev.Hash, Content: ev.Content})
+			lastID = ev.ID
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		c.Reset() // the peer may come back upgraded
+		opts.Stats.Failed(p.Name, err)
+		syncFailures.Inc(p.Name)
+		if time.Since(start) > time.Minute {
+			backoff = time.Second // the stream was up for a while
+		}
+		slog.Warn("sync: subscription ended", "peer", p.Name, "err", err, "reconnect_in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
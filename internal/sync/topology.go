@@ -0,0 +1,115 @@
+package sync
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Topologies.
+const (
+	TopologyMesh   = "mesh"   // every node sends to every peer
+	TopologyHub    = "hub"    // nodes send to the hub, which relays to everyone else
+	TopologyLeader = "leader" // the leader's clipboard is mirrored one-way to the followers
+)
+
+// Topology decides which peers a node sends to and accepts updates from. The zero
+// value (and nil) is a full mesh.
+type Topology struct {
+	Mode   string // TopologyMesh (default), TopologyHub or TopologyLeader
+	Center string // hostname of the hub or leader
+}
+
+// ParseTopology validates mode and center.
+func ParseTopology(mode, center string) (*Topology, error) {
+	switch mode {
+	case "", TopologyMesh:
+		return &Topology{Mode: TopologyMesh}, nil
+	case TopologyHub, TopologyLeader:
+		if center == "" {
+			return nil, fmt.Errorf("%s topology needs the %s's hostname", mode, mode)
+		}
+		return &Topology{Mode: mode, Center: center}, nil
+	}
+	return nil, fmt.Errorf("unknown topology %q (want %s, %s or %s)", mode, TopologyMesh, TopologyHub, TopologyLeader)
+}
+
+func (t *Topology) mode() string {
+	if t == nil || t.Mode == "" {
+		return TopologyMesh
+	}
+	return t.Mode
+}
+
+// IsCenter reports whether self is the hub or leader.
+func (t *Topology) IsCenter(self string) bool {
+	return t.mode() != TopologyMesh && SameHost(self, t.Center)
+}
+
+// Targets returns the peers that self sends its own copies to.
+func (t *Topology) Targets(self string, peers []Peer) []Peer {
+	switch {
+	case t.mode() == TopologyMesh || t.IsCenter(self):
+		return peers
+	case t.mode() == TopologyHub:
+		for _, p := range peers {
+			if SameHost(p.Name, t.Center) {
+				return []Peer{p}
+			}
+		}
+	}
+	return nil // followers, or the hub is not discovered
+}
+
+// Accepts reports whether self takes updates sent by from; when not, why.
+func (t *Topology) Accepts(self, from string) (ok bool, reason string) {
+	if t.mode() != TopologyLeader {
+		return true, ""
+	}
+	if t.IsCenter(self) {
+		return false, "leader"
+	}
+	if !SameHost(from, t.Center) {
+		return false, "not-leader"
+	}
+	return true, ""
+}
+
+// Relays reports whether self forwards updates it receives to the other peers.
+func (t *Topology) Relays(self string) bool {
+	return t.mode() == TopologyHub && t.IsCenter(self)
+}
+
+// String describes the topology for the startup log.
+func (t *Topology) String() string {
+	if t.mode() == TopologyMesh {
+		return TopologyMesh
+	}
+	return t.Mode + " (" + t.Center + ")"
+}
+
+// SameHost reports whether a and b name the same host: equal ignoring case, or
+// equal short names when one is a FQDN.
+func SameHost(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	short := func(s string) string {
+		if i := strings.IndexByte(s, '.'); i > 0 && net.ParseIP(s) == nil {
+			return s[:i]
+		}
+		return s
+	}
+	return short(a) == short(b)
+}
+
+// Update is a clipboard update received from a peer, for a hub to relay.
+type Update struct {
+	Version Version
+	Content string
+	From    string // sender's hostname; not relayed back to it
+}
@@ -2,36 +2,103 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/atotto/clipboard"
+	"github.com/xconnect/xconnect-go/internal/approval"
+	"github.com/xconnect/xconnect-go/internal/config"
 	"github.com/xconnect/xconnect-go/internal/daemon"
 	"github.com/xconnect/xconnect-go/internal/discovery"
-	"github.com/xconnect/xconnect-go/internal/server"
+	"github.com/xconnect/xconnect-go/internal/logging"
+	"github.com/xconnect/xconnect-go/internal/notify"
+	"github.com/xconnect/xconnect-go/internal/outbox"
 	clipsync "github.com/xconnect/xconnect-go/internal/sync"
+	"github.com/xconnect/xconnect-go/pkg/xconnect"
 )
 
 var (
-	addr         = flag.String("addr", ":8315", "address to listen on")
-	useTsnet     = flag.Bool("tsnet", false, "use embedded Tailscale (tsnet); if false, assume system Tailscale")
-	hostname     = flag.String("hostname", "xconnect", "hostname on tailnet (used when -tsnet)")
-	authKey      = flag.String("authkey", "", "Tailscale auth key (used when -tsnet); or set TS_AUTHKEY")
-	enableSync   = flag.Bool("sync", false, "enable clipboard auto-sync: broadcast local copy to other devices")
-	syncInterval = flag.Duration("sync-interval", time.Second, "clipboard poll interval when -sync")
-	apiToken     = flag.String("api-token", "", "Tailscale API token for peer discovery (or TAILSCALE_API_TOKEN)")
-	peersList    = flag.String("peers", "", "comma-separated peer hostnames or IPs (overrides discovery when -sync)")
-	daemonMode   = flag.Bool("daemon", false, "run in background (service mode); logs to file")
-	logFile      = flag.String("log-file", "", "log file path (default: platform-specific, e.g. %%LocalAppData%%\\XConnect\\logs on Windows)")
+	configPath        = flag.String("config", config.DefaultPath(), "config file: a JSON object with flag names as keys; flags given here override it. Reloaded on change and on SIGHUP")
+	addr              = flag.String("addr", ":8315", "address to listen on")
+	useTsnet          = flag.Bool("tsnet", false, "use embedded Tailscale (tsnet); if false, assume system Tailscale")
+	hostname          = flag.String("hostname", "xconnect", "hostname on tailnet (used when -tsnet)")
+	authKey           = flag.String("authkey", "", "Tailscale auth key (used when -tsnet); or set TS_AUTHKEY")
+	enableSync        = flag.Bool("sync", false, "enable clipboard auto-sync: broadcast local copy to other devices")
+	syncInterval      = flag.Duration("sync-interval", time.Second, "clipboard poll interval when -sync and no change notifications are available")
+	syncMode          = flag.String("sync-mode", clipsync.ModeBoth, "sync direction: both, send-only or receive-only (changeable at runtime via POST /sync/mode)")
+	syncPrimary       = flag.Bool("sync-primary", false, "also sync the X11/Wayland PRIMARY selection (middle-click paste) when -sync; needs wl-clipboard, xclip or xsel")
+	syncTopology      = flag.String("sync-topology", clipsync.TopologyMesh, "mesh (every node sends to every peer), hub (send to -sync-center, which relays) or leader (-sync-center's clipboard is mirrored one-way to the others)")
+	syncCenter        = flag.String("sync-center", "", "hostname of the hub or leader for -sync-topology hub|leader")
+	syncTransport     = flag.String("sync-transport", clipsync.TransportPush, "push (POST copies to peers), subscribe (peers pull them from GET /clipboard/events; for one-way ACLs) or both")
+	syncPoll          = flag.Bool("sync-poll", false, "always poll the clipboard every -sync-interval instead of using change notifications")
+	apiToken          = flag.String("api-token", "", "Tailscale API token for peer discovery (or TAILSCALE_API_TOKEN)")
+	peersList         = flag.String("peers", "", "comma-separated peer hostnames or IPs (overrides discovery when -sync)")
+	syncPeers         = flag.String("sync-peers", "", "select discovered peers for -sync, e.g. 'tag:xconnect,user:me@example.com,host:laptop-*' (default: all)")
+	discoveryMode     = flag.String("discovery", "tailscale", "comma-separated discovery providers, merged: tailscale (status, then API), api, headscale, file, mdns (LAN, no Tailscale needed)")
+	apiBase           = flag.String("api-base", discovery.DefaultAPIBase, "Tailscale-compatible API base URL for -discovery api")
+	tailnet           = flag.String("tailnet", "-", "tailnet name for -discovery api (- = the token's tailnet)")
+	oauthID           = flag.String("oauth-client-id", "", "Tailscale OAuth client ID for API discovery (or TAILSCALE_OAUTH_CLIENT_ID); used instead of -api-token")
+	oauthSecret       = flag.String("oauth-client-secret", "", "Tailscale OAuth client secret (or TAILSCALE_OAUTH_CLIENT_SECRET)")
+	oauthTokenURL     = flag.String("oauth-token-url", "", "OAuth token endpoint (default: <api-base>/oauth/token)")
+	headscaleURL      = flag.String("headscale-url", "", "Headscale server URL for -discovery headscale")
+	headscaleKey      = flag.String("headscale-key", "", "Headscale API key for -discovery headscale (or HEADSCALE_API_KEY)")
+	peersFile         = flag.String("peers-file", "", "hosts file for -discovery file (one 'hostname [ip] [port=N] [tag:x] [user:x]' per line)")
+	advertiseMDNS     = flag.Bool("mdns", false, "advertise this server on the LAN as _xconnect._tcp via mDNS (implied by -discovery mdns)")
+	discoveryInterval = flag.Duration("discovery-interval", 30*time.Second, "peer discovery refresh interval when -sync (also refreshed on tailnet changes)")
+	filterSecrets     = flag.Bool("filter-secrets", true, "do not sync content that looks like a secret (AWS keys, JWTs, private keys, GitHub tokens)")
+	filterConcealed   = flag.Bool("filter-concealed", true, "do not send clipboard content marked as a password by the copying app")
+	filterMaxSize     = flag.Int("filter-max-size", 1<<20, "do not sync clipboard content larger than this many bytes (0 = no limit)")
+	filterDeny        stringList
+	acceptMode        = flag.String("accept", approval.Auto, "incoming clipboard policy: auto (write at once), ask (hold until accepted with xconnect accept) or reject")
+	acceptPeers       = flag.String("accept-peers", "", "per-peer overrides of -accept, e.g. 'laptop=auto,phone-*=ask,100.64.0.9=reject'")
+	acceptTimeout     = flag.Duration("accept-timeout", 2*time.Minute, "discard clipboard updates held for approval after this time")
+	outboxDir         = flag.String("outbox", outbox.DefaultDir(), "directory for updates, messages and files waiting for offline peers when -sync (empty = disable)")
+	outboxExpiry      = flag.Duration("outbox-expiry", 24*time.Hour, "drop outbox items not delivered within this time (0 = never)")
+	filesDir          = flag.String("files-dir", "xconnect-files", "directory for received files")
+	historySize       = flag.Int("history-size", 50, "clipboard history entries to keep")
+	historyMaxAge     = flag.Duration("history-max-age", 0, "drop clipboard history entries older than this (0 = keep)")
+	remoteStatus      = flag.Bool("remote-status", false, "also serve GET /status and GET /metrics to tailnet peers (default: local callers only)")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 30*time.Second, "on SIGINT/SIGTERM, wait this long for in-flight requests such as uploads and for sync to stop")
+	daemonMode        = flag.Bool("daemon", false, "run in background (service mode); logs to file")
+	logFile           = flag.String("log-file", "", "log file path (default: platform-specific, e.g. %%LocalAppData%%\\XConnect\\logs on Windows)")
+	logLevel          = flag.String("log-level", "info", "log level: debug, info, warn or error")
+	logFormat         = flag.String("log-format", "text", "log format: text or json")
+	logMaxSize        = flag.Int("log-max-size", 10, "rotate the log file when it exceeds this many MB (0 = never)")
+	logMaxAge         = flag.Duration("log-max-age", 7*24*time.Hour, "rotate the log file when it is older than this (0 = never)")
+	logMaxBackups     = flag.Int("log-max-backups", 5, "keep this many rotated log files (0 = all)")
+)
+
+func init() {
+	flag.Var(&filterDeny, "filter-deny", "do not sync content matching this regular expression (repeatable)")
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+func (l *stringList) Reset()             { *l = nil }
+
+// explicit are the flags given on the command line, which override cfg, the
+// applied config file.
+var (
+	explicit = map[string]bool{}
+	cfg      config.Values
 )
 
 func main() {
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if err := loadConfig(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
 
 	// Daemon: parent starts child and exits; child runs with stderr = log file
 	if *daemonMode && !daemon.IsDaemonChild() {
@@ -40,123 +107,213 @@ func main() {
 		}
 		return
 	}
+	// The daemon child logs to the (rotated) log file only; in the foreground,
+	// -log-file copies stderr to it.
+	logOpts := logging.Options{
+		Level:      *logLevel,
+		Format:     *logFormat,
+		MaxSize:    int64(*logMaxSize) << 20,
+		MaxAge:     *logMaxAge,
+		MaxBackups: *logMaxBackups,
+	}
 	if daemon.IsDaemonChild() {
-		log.SetOutput(os.Stderr)
-		log.SetFlags(log.Ldate | log.Ltime)
-	} else if *logFile != "" {
-		f, err := daemon.SetupLog(*logFile, true)
-		if err != nil {
-			log.Fatalf("log-file: %v", err)
+		logOpts.File = *logFile
+		if logOpts.File == "" {
+			logOpts.File = daemon.DefaultLogPath()
 		}
-		defer f.Close()
+	} else if *logFile != "" {
+		logOpts.File, logOpts.Stderr = *logFile, true
+	}
+	closer, err := logging.Setup(logOpts)
+	if err != nil {
+		log.Fatalf("log: %v", err)
 	}
+	defer closer.Close()
 
 	if err := run(); err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
+		closer.Close()
+		os.Exit(1)
 	}
 }
 
-type lastReceivedState struct {
-	mu   sync.Mutex
-	val  string
+func run() error {
+	node, err := xconnect.New(options())
+	if err != nil {
+		return err
+	}
+	if err := node.Start(context.Background()); err != nil {
+		return err
+	}
+	return serve(node)
 }
 
-func (s *lastReceivedState) Get() string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.val
+// options returns the node options from the flags.
+func options() xconnect.Options {
+	opts := xconnect.Options{
+		Addr:              *addr,
+		Tsnet:             *useTsnet,
+		AuthKey:           *authKey,
+		Sync:              *enableSync,
+		SyncInterval:      *syncInterval,
+		SyncPoll:          *syncPoll,
+		SyncMode:          *syncMode,
+		SyncPrimary:       *syncPrimary,
+		SyncTopology:      *syncTopology,
+		SyncCenter:        *syncCenter,
+		SyncTransport:     *syncTransport,
+		SyncPeers:         *syncPeers,
+		Discovery:         *discoveryMode,
+		DiscoveryInterval: *discoveryInterval,
+		APIToken:          envDefault(*apiToken, "TAILSCALE_API_TOKEN"),
+		APIBase:           *apiBase,
+		Tailnet:           *tailnet,
+		OAuthClientID:     envDefault(*oauthID, "TAILSCALE_OAUTH_CLIENT_ID"),
+		OAuthClientSecret: envDefault(*oauthSecret, "TAILSCALE_OAUTH_CLIENT_SECRET"),
+		OAuthTokenURL:     *oauthTokenURL,
+		HeadscaleURL:      *headscaleURL,
+		HeadscaleKey:      envDefault(*headscaleKey, "HEADSCALE_API_KEY"),
+		PeersFile:         *peersFile,
+		MDNS:              *advertiseMDNS,
+		FilterSecrets:     *filterSecrets,
+		FilterConcealed:   *filterConcealed,
+		FilterMaxSize:     *filterMaxSize,
+		FilterDeny:        filterDeny,
+		Accept:            *acceptMode,
+		AcceptPeers:       *acceptPeers,
+		AcceptTimeout:     *acceptTimeout,
+		OutboxDir:         *outboxDir,
+		OutboxExpiry:      *outboxExpiry,
+		FilesDir:          *filesDir,
+		HistorySize:       *historySize,
+		HistoryMaxAge:     *historyMaxAge,
+		RemoteStatus:      *remoteStatus,
+		OnPending:         notifyPending,
+	}
+	// Without -hostname, mDNS uses the OS hostname and discovery names us.
+	if *useTsnet || isFlagSet("hostname") {
+		opts.Hostname = *hostname
+	}
+	if *peersList != "" {
+		opts.Peers = strings.Split(*peersList, ",")
+	}
+	return opts
 }
 
-func (s *lastReceivedState) Set(v string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.val = v
+// loadConfig applies the config file to the flags not given on the command line.
+// A missing file is fine unless -config names it. On error the flags are unchanged.
+func loadConfig() error {
+	next, err := config.Load(*configPath)
+	if errors.Is(err, os.ErrNotExist) && !explicit["config"] {
+		next, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := config.Apply(flag.CommandLine, cfg, next, explicit); err != nil {
+		config.Apply(flag.CommandLine, next, cfg, explicit)
+		return err
+	}
+	cfg = next
+	return nil
 }
 
-func run() error {
-	var ln server.Listener
-	var err error
-	if *useTsnet {
-		ln, err = server.ListenTsnet(*hostname, *authKey, *addr)
-		if err != nil {
-			return err
-		}
-		defer ln.Close()
-	} else {
-		ln, err = server.ListenSystem(*addr)
-		if err != nil {
+// serve waits for node to stop, reloading the config file when it changes or on
+// SIGHUP. The listener and sync keep running across reloads. SIGINT or SIGTERM shuts
+// the node down gracefully; a second one exits at once.
+func serve(node *xconnect.Node) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	term := make(chan os.Signal, 2)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(term)
+	changed := config.Watch(ctx, *configPath, 2*time.Second)
+	done := make(chan error, 1)
+	go func() { done <- node.Wait() }()
+	for {
+		select {
+		case err := <-done:
 			return err
+		case sig := <-term:
+			return shutdown(node, sig, term)
+		case <-hup:
+		case <-changed:
 		}
-		defer ln.Close()
+		reload(node)
 	}
+}
 
-	lastReceived := &lastReceivedState{}
-	handlerOpts := &server.HandlerOpts{
-		OnClipboardReceivedFromNetwork: lastReceived.Set,
+// shutdown stops node within -shutdown-timeout, or exits on another signal from term.
+func shutdown(node *xconnect.Node, sig os.Signal, term <-chan os.Signal) error {
+	slog.Info("shutting down", "signal", sig.String(), "timeout", *shutdownTimeout)
+	go func() {
+		sig := <-term
+		slog.Warn("exiting without waiting", "signal", sig.String())
+		os.Exit(1)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := node.Stop(ctx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
 	}
-	handler := server.NewHandler(handlerOpts)
+	slog.Info("stopped")
+	return nil
+}
 
-	if *enableSync {
-		ctx := context.Background()
-		port := *addr
-		if strings.HasPrefix(port, ":") {
-			port = port[1:]
-		}
-		selfHost := *hostname
-		if !*useTsnet {
-			ctx2, cancel := context.WithTimeout(ctx, 5*time.Second)
-			if t, _ := os.LookupEnv("TAILSCALE_API_TOKEN"); *apiToken == "" {
-				*apiToken = t
-			}
-			if s, _, err := discovery.SelfAndPeers(ctx2, *apiToken); err == nil && s != "" {
-				selfHost = s
-			}
-			cancel()
-		}
-		getPeers := func() []string {
-			var urls []string
-			if *peersList != "" {
-				for _, p := range strings.Split(*peersList, ",") {
-					p = strings.TrimSpace(p)
-					if p != "" && p != selfHost {
-						urls = append(urls, "http://"+p+":"+port)
-					}
-				}
-				return urls
-			}
-			ctx2, cancel := context.WithTimeout(ctx, 5*time.Second)
-			_, peers, err := discovery.SelfAndPeers(ctx2, *apiToken)
-			cancel()
-			if err != nil {
-				return nil
-			}
-			for _, d := range peers {
-				if d.HostName == selfHost {
-					continue
-				}
-				u := discovery.BaseURL(d, port)
-				if u != "" {
-					urls = append(urls, u)
-				}
-			}
-			return urls
-		}
-		getClipboard := func() string {
-			s, _ := clipboard.ReadAll()
-			return s
-		}
-		getFromHost := func() string { return selfHost }
-		go clipsync.ClipboardSync(ctx, clipsync.Options{
-			Interval:        *syncInterval,
-			GetClipboard:   getClipboard,
-			GetLastReceived: lastReceived.Get,
-			GetPeers:        getPeers,
-			GetFromHost:     getFromHost,
-			HTTPClient:      &http.Client{Timeout: 10 * time.Second},
-		})
-		log.Printf("clipboard auto-sync enabled (broadcast to peers on copy)")
-	}
-
-	log.Printf("xconnect listening on %s (tsnet=%v)", *addr, *useTsnet)
-	return http.Serve(ln, handler)
+// reload re-reads the config file and applies what can change while running; the
+// rest is reported as needing a restart.
+func reload(node *xconnect.Node) {
+	logSettings := func() string {
+		return fmt.Sprint(*logFile, *logFormat, *logMaxSize, *logMaxAge, *logMaxBackups)
+	}
+	prevLog, prev := logSettings(), cfg
+	if err := loadConfig(); err != nil {
+		slog.Error("config: reload failed, keeping the current settings", "file", *configPath, "err", err)
+		return
+	}
+	restart, err := node.Reload(options())
+	if err != nil {
+		// Put the flags back, so they keep matching the running node.
+		config.Apply(flag.CommandLine, cfg, prev, explicit)
+		cfg = prev
+		slog.Error("config: reload failed, keeping the current settings", "file", *configPath, "err", err)
+		return
+	}
+	if err := logging.SetLevel(*logLevel); err != nil {
+		slog.Error("config: log-level", "err", err)
+	}
+	if logSettings() != prevLog {
+		restart = append(restart, "log settings")
+	}
+	slog.Info("config reloaded", "file", *configPath)
+	if len(restart) > 0 {
+		slog.Warn("config: restart xconnect to apply", "settings", strings.Join(restart, ","))
+	}
+}
+
+// envDefault returns v, or the environment variable env when v is empty.
+func envDefault(v, env string) string {
+	if v == "" {
+		return os.Getenv(env)
+	}
+	return v
+}
+
+// isFlagSet reports whether the named flag was given on the command line or in the
+// config file.
+func isFlagSet(name string) bool {
+	return explicit[name] || cfg[name] != nil
+}
+
+// notifyPending shows a desktop notification for content held for approval. The
+// content itself is not shown, since it may be sensitive.
+func notifyPending(p xconnect.Pending) {
+	err := notify.Send("XConnect: clipboard from "+p.From,
+		fmt.Sprintf("%d bytes waiting. Accept with \"xconnect accept\" or from the tray.", len(p.Content)))
+	if err != nil && err != notify.ErrUnsupported {
+		slog.Warn("notify failed", "err", err)
+	}
 }
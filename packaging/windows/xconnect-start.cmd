@@ -1,5 +1,9 @@
 @echo off
-REM 登录后自动启动：后台运行 xconnect -daemon -sync，再启动托盘
+REM 登录后自动启动：后台运行 xconnect -daemon（无配置文件时加 -sync），再启动托盘
 set INSTALLDIR=%~dp0
-start "" /B "%INSTALLDIR%xconnect.exe" -daemon -sync
+if exist "%AppData%\XConnect\config.json" (
+	start "" /B "%INSTALLDIR%xconnect.exe" -daemon
+) else (
+	start "" /B "%INSTALLDIR%xconnect.exe" -daemon -sync
+)
 start "" "%INSTALLDIR%xconnect-tray.exe"
@@ -0,0 +1,521 @@
+// Package client is a Go client for the xconnect HTTP API (see api/openapi.yaml).
+//
+//	c := client.New("http://laptop:8315")
+//	if err := c.Push(ctx, "hello"); err != nil { ... }
+//	text, err := c.Pull(ctx)
+//
+// The first call performs the capability handshake (GET /v1/hello) and later calls use
+// /v1 paths, or the legacy unprefixed paths on servers from before the handshake.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/protocol"
+)
+
+// DefaultPort is the port xconnect servers listen on.
+const DefaultPort = "8315"
+
+// Hello is a server's answer to the capability handshake.
+type Hello = protocol.Hello
+
+// Capabilities announced in Hello.
+const (
+	CapClipboardVersion = protocol.CapClipboardVersion
+	CapClipboardWait    = protocol.CapClipboardWait
+	CapEvents           = protocol.CapEvents
+	CapPrimary          = protocol.CapPrimary
+	CapPending          = protocol.CapPending
+	CapSyncControl      = protocol.CapSyncControl
+	CapFiles            = protocol.CapFiles
+	CapMessage          = protocol.CapMessage
+	CapStatus           = protocol.CapStatus
+)
+
+// HistoryEntry is one item of GET /clipboard/history.
+type HistoryEntry struct {
+	Content  string    `json:"content"`
+	FromHost string    `json:"from_host"`
+	At       time.Time `json:"at"`
+}
+
+// Pending is a clipboard update held for approval (GET /clipboard/pending).
+type Pending struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Content string    `json:"content"`
+	At      time.Time `json:"at"`
+	Expires time.Time `json:"expires"`
+}
+
+// SyncStatus is the state of clipboard sync (GET /sync/status).
+type SyncStatus struct {
+	Enabled bool   `json:"enabled"`
+	Paused  bool   `json:"paused"`
+	Mode    string `json:"mode"`
+}
+
+// Status is a node's health report (GET /status).
+type Status struct {
+	Version       string           `json:"version"`
+	Hostname      string           `json:"hostname"`
+	Started       time.Time        `json:"started"`
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	Listener      string           `json:"listener"` // tsnet, system or custom
+	Addr          string           `json:"addr"`
+	Clipboard     ClipboardStatus  `json:"clipboard"`
+	Discovery     *DiscoveryStatus `json:"discovery"` // nil without sync
+	Peers         []PeerStatus     `json:"peers"`
+	Sync          *SyncStats       `json:"sync"`
+	Pending       int              `json:"pending"`
+	Files         FilesStatus      `json:"files"`
+}
+
+// ClipboardStatus tells whether the node's clipboard backend works.
+type ClipboardStatus struct {
+	Available bool   `json:"available"`
+	Error     string `json:"error"`
+	Watch     string `json:"watch"` // change detection backend
+	Primary   bool   `json:"primary"`
+}
+
+// DiscoveryStatus is the state of peer discovery.
+type DiscoveryStatus struct {
+	Providers   string    `json:"providers"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	LastError   string    `json:"last_error"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+// PeerStatus is a discovered peer and when sync last reached it.
+type PeerStatus struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Offline     bool      `json:"offline"`
+	LastContact time.Time `json:"last_contact"`
+	LastError   string    `json:"last_error"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+// SyncStats is the sync state with its traffic counters.
+type SyncStats struct {
+	SyncStatus
+	Topology     string    `json:"topology"`
+	Transport    string    `json:"transport"`
+	Sent         uint64    `json:"sent"`
+	Received     uint64    `json:"received"`
+	Failed       uint64    `json:"failed"`
+	LastSent     time.Time `json:"last_sent"`
+	LastReceived time.Time `json:"last_received"`
+}
+
+// FilesStatus is the disk usage of received files.
+type FilesStatus struct {
+	Dir   string `json:"dir"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Event is a local copy published on GET /clipboard/events.
+type Event struct {
+	ID      string `json:"-"` // the event ID (content hash), for resuming
+	Origin  string `json:"origin"`
+	Clock   uint64 `json:"clock"`
+	Hash    string `json:"hash"`
+	Content string `json:"content"`
+}
+
+// StatusError is an unexpected HTTP response.
+type StatusError struct {
+	Method, URL string
+	StatusCode  int
+	Status      string
+	Body        string // first 1KiB, trimmed
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s %s: %s %s", e.Method, e.URL, e.Status, e.Body)
+}
+
+// ErrNotSupported is returned (wrapped) for features the server did not announce.
+var ErrNotSupported = errors.New("not supported by the server")
+
+// IsUnreachable reports whether err means the server could not be reached at all:
+// the connection was refused, reset or timed out. Errors that retrying later will not
+// fix, such as an unknown hostname, an error response or a version mismatch, are not.
+func IsUnreachable(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// Client talks to one xconnect server. It is safe for concurrent use.
+type Client struct {
+	// BaseURL of the server, e.g. "http://laptop:8315".
+	BaseURL string
+	// HTTPClient is used for all requests (default http.DefaultClient). Events needs
+	// a client without Timeout.
+	HTTPClient *http.Client
+	// FromHost, if set, is sent as X-From-Host (shown in the peer's history).
+	FromHost string
+
+	mu    sync.Mutex
+	hello *Hello
+}
+
+// New returns a Client for baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// ForPeer returns a Client for a peer given as hostname or address, with port
+// DefaultPort unless port is set.
+func ForPeer(peer, port string) *Client {
+	if port == "" {
+		port = DefaultPort
+	}
+	return New("http://" + peer + ":" + port)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// Hello returns the server's handshake, performing it on first use.
+func (c *Client) Hello(ctx context.Context) (*Hello, error) {
+	c.mu.Lock()
+	h := c.hello
+	c.mu.Unlock()
+	if h != nil {
+		return h, nil
+	}
+	h, err := protocol.Handshake(ctx, c.httpClient(), c.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.hello = h
+	c.mu.Unlock()
+	return h, nil
+}
+
+// Reset forgets the handshake, so the next call repeats it (e.g. after the server
+// was restarted or upgraded).
+func (c *Client) Reset() {
+	c.mu.Lock()
+	c.hello = nil
+	c.mu.Unlock()
+}
+
+// url returns the URL of the API path p, requiring capability need (if set).
+func (c *Client) url(ctx context.Context, p, need string) (string, error) {
+	h, err := c.Hello(ctx)
+	if err != nil {
+		return "", err
+	}
+	if need != "" && !h.Has(need) {
+		return "", fmt.Errorf("%s: %s: %w", c.BaseURL, need, ErrNotSupported)
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + h.Path(p), nil
+}
+
+// do sends a request to path and checks that the status is one of ok (default 200, 204).
+func (c *Client) do(ctx context.Context, method, path, need, contentType string, body io.Reader, header http.Header, ok ...int) (*http.Response, error) {
+	u, err := c.url(ctx, path, need)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.FromHost != "" {
+		req.Header.Set("X-From-Host", c.FromHost)
+	}
+	protocol.SetHeader(req)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(ok) == 0 {
+		ok = []int{http.StatusOK, http.StatusNoContent}
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, &StatusError{Method: method, URL: u, StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(b))}
+}
+
+func (c *Client) getJSON(ctx context.Context, path, need string, v any) error {
+	resp, err := c.do(ctx, "GET", path, need, "", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *Client) postJSON(ctx context.Context, path, need string, v any) error {
+	resp, err := c.do(ctx, "POST", path, need, "application/json", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Push sets the server's clipboard to text.
+func (c *Client) Push(ctx context.Context, text string) error {
+	resp, err := c.do(ctx, "POST", "/clipboard", "", "text/plain; charset=utf-8", strings.NewReader(text), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Pull returns the server's clipboard.
+func (c *Client) Pull(ctx context.Context) (string, error) {
+	text, _, _, err := c.Wait(ctx, "", 0)
+	return text, err
+}
+
+// Wait long-polls the server's clipboard: it returns when the clipboard's ETag differs
+// from etag or after wait (at most 5m on the server). changed is false when the wait
+// ran out; text and the new ETag are only set when changed. Wait with an empty etag
+// returns the clipboard at once.
+func (c *Client) Wait(ctx context.Context, etag string, wait time.Duration) (text, newETag string, changed bool, err error) {
+	path, need := "/clipboard", ""
+	if wait > 0 {
+		path += "?wait=" + url.QueryEscape(wait.String())
+		need = CapClipboardWait
+	}
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	resp, err := c.do(ctx, "GET", path, need, "", nil, header, http.StatusOK, http.StatusNotModified)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return "", etag, false, nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", false, err
+	}
+	return string(b), resp.Header.Get("ETag"), true, nil
+}
+
+// History returns the server's clipboard history, newest first.
+func (c *Client) History(ctx context.Context) ([]HistoryEntry, error) {
+	var list []HistoryEntry
+	return list, c.getJSON(ctx, "/clipboard/history", "", &list)
+}
+
+// Message sends a short text message (written to the server's clipboard).
+func (c *Client) Message(ctx context.Context, text string) error {
+	payload, _ := json.Marshal(map[string]string{"text": text})
+	resp, err := c.do(ctx, "POST", "/message", CapMessage, "application/json", bytes.NewReader(payload), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Upload sends the file name with content r and returns its ID on the server. The
+// content is streamed from r, not held in memory.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	resp, err := c.do(ctx, "POST", "/files", CapFiles, mw.FormDataContentType(), pr, nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		ID string `json:"file_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// Download writes the file with id to w and returns its original name.
+func (c *Client) Download(ctx context.Context, id string, w io.Writer) (name string, err error) {
+	resp, err := c.do(ctx, "GET", "/files/"+url.PathEscape(id), CapFiles, "", nil, nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	_, err = io.Copy(w, resp.Body)
+	return name, err
+}
+
+// Events streams the server's local copies to fn until ctx is done, fn returns an
+// error, or the stream breaks. lastID (the ID of the last event seen, or "") skips
+// the current copy if it is unchanged. Callers reconnect with the last Event.ID.
+func (c *Client) Events(ctx context.Context, lastID string, fn func(Event) error) error {
+	header := http.Header{}
+	header.Set("Accept", "text/event-stream")
+	if lastID != "" {
+		header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := c.do(ctx, "GET", "/clipboard/events", CapEvents, "", nil, header, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 64<<20)
+	var id, event string
+	var data strings.Builder
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if event == "clipboard" && data.Len() > 0 {
+				var ev Event
+				if err := json.Unmarshal([]byte(data.String()), &ev); err != nil {
+					return fmt.Errorf("bad event: %w", err)
+				}
+				ev.ID = id
+				if err := fn(ev); err != nil {
+					return err
+				}
+			}
+			id, event = "", ""
+			data.Reset()
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Pending lists clipboard updates held for approval, newest first.
+func (c *Client) Pending(ctx context.Context) ([]Pending, error) {
+	var list []Pending
+	return list, c.getJSON(ctx, "/clipboard/pending", CapPending, &list)
+}
+
+// Accept writes the held update id ("" = the newest) to the server's clipboard.
+func (c *Client) Accept(ctx context.Context, id string) (Pending, error) {
+	return c.decide(ctx, id, "accept")
+}
+
+// Reject discards the held update id ("" = the newest).
+func (c *Client) Reject(ctx context.Context, id string) (Pending, error) {
+	return c.decide(ctx, id, "reject")
+}
+
+func (c *Client) decide(ctx context.Context, id, action string) (Pending, error) {
+	if id == "" {
+		id = "latest"
+	}
+	var p Pending
+	return p, c.postJSON(ctx, "/clipboard/pending/"+url.PathEscape(id)+"/"+action, CapPending, &p)
+}
+
+// Status returns the node's health report.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var st Status
+	if err := c.getJSON(ctx, "/status", CapStatus, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// SyncStatus returns the state of clipboard sync.
+func (c *Client) SyncStatus(ctx context.Context) (SyncStatus, error) {
+	var st SyncStatus
+	return st, c.getJSON(ctx, "/sync/status", CapSyncControl, &st)
+}
+
+// PauseSync pauses clipboard sync and returns the new state.
+func (c *Client) PauseSync(ctx context.Context) (SyncStatus, error) {
+	var st SyncStatus
+	return st, c.postJSON(ctx, "/sync/pause", CapSyncControl, &st)
+}
+
+// ResumeSync resumes clipboard sync and returns the new state.
+func (c *Client) ResumeSync(ctx context.Context) (SyncStatus, error) {
+	var st SyncStatus
+	return st, c.postJSON(ctx, "/sync/resume", CapSyncControl, &st)
+}
+
+// SetSyncMode sets the sync direction (both, send-only or receive-only).
+func (c *Client) SetSyncMode(ctx context.Context, mode string) (SyncStatus, error) {
+	var st SyncStatus
+	return st, c.postJSON(ctx, "/sync/mode?mode="+url.QueryEscape(mode), CapSyncControl, &st)
+}
@@ -0,0 +1,210 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/clipboard"
+	"github.com/xconnect/xconnect-go/internal/protocol"
+	"github.com/xconnect/xconnect-go/internal/server"
+	clipsync "github.com/xconnect/xconnect-go/internal/sync"
+	"github.com/xconnect/xconnect-go/pkg/client"
+)
+
+// newServer serves the real API over an in-memory clipboard.
+func newServer(t *testing.T, opts *server.HandlerOpts) *httptest.Server {
+	t.Helper()
+	t.Cleanup(clipboard.UseMemory())
+	if opts == nil {
+		opts = &server.HandlerOpts{}
+	}
+	if opts.Storage == nil {
+		opts.Storage = server.NewStorage(t.TempDir(), 0, 0)
+	}
+	srv := httptest.NewServer(server.NewHandler(opts))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClipboard(t *testing.T) {
+	srv := newServer(t, nil)
+	ctx := context.Background()
+	c := client.New(srv.URL + "/")
+	c.FromHost = "laptop"
+
+	if err := c.Push(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	text, err := c.Pull(ctx)
+	if err != nil || text != "hello" {
+		t.Fatalf("Pull = %q, %v", text, err)
+	}
+	h, err := c.Hello(ctx)
+	if err != nil || h.Protocol != protocol.Version || !h.Has(client.CapClipboardWait) {
+		t.Errorf("Hello = %+v, %v", h, err)
+	}
+
+	list, err := c.History(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Content != "hello" || list[0].FromHost != "laptop" {
+		t.Errorf("History = %+v", list)
+	}
+
+	if err := c.Message(ctx, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := c.Pull(ctx); text != "ping" {
+		t.Errorf("after Message: clipboard = %q, want ping", text)
+	}
+	if list, _ := c.History(ctx); len(list) != 2 || list[0].Content != "ping" {
+		t.Errorf("after Message: History = %+v, want ping first", list)
+	}
+}
+
+func TestWait(t *testing.T) {
+	srv := newServer(t, nil)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	c.Push(ctx, "one")
+
+	text, etag, changed, err := c.Wait(ctx, "", 0)
+	if err != nil || !changed || text != "one" || etag != `"`+clipsync.Hash("one")+`"` {
+		t.Fatalf("Wait = %q, %q, %v, %v", text, etag, changed, err)
+	}
+	if _, got, changed, err := c.Wait(ctx, etag, 50*time.Millisecond); err != nil || changed || got != etag {
+		t.Errorf("unchanged Wait = %q, %v, %v; want the same ETag", got, changed, err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.New(srv.URL).Push(ctx, "two")
+	}()
+	start := time.Now()
+	text, _, changed, err = c.Wait(ctx, etag, 10*time.Second)
+	if err != nil || !changed || text != "two" {
+		t.Errorf("Wait = %q, %v, %v; want two", text, changed, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Wait took %v to see a push", d)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	srv := newServer(t, nil)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+
+	id, err := c.Upload(ctx, "notes.txt", strings.NewReader("file body"))
+	if err != nil || id == "" {
+		t.Fatalf("Upload = %q, %v", id, err)
+	}
+	var buf bytes.Buffer
+	name, err := c.Download(ctx, id, &buf)
+	if err != nil || name != "notes.txt" || buf.String() != "file body" {
+		t.Errorf("Download = %q, %q, %v", name, buf.String(), err)
+	}
+
+	_, err = c.Download(ctx, "missing", &buf)
+	var se *client.StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+		t.Errorf("Download of an unknown ID: err = %v, want a 404 StatusError", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	feed := clipsync.NewFeed()
+	srv := newServer(t, &server.HandlerOpts{Events: feed})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := client.New(srv.URL)
+
+	feed.Publish(clipsync.Version{Origin: "a", Clock: 1, Hash: clipsync.Hash("first")}, "first")
+	var got []client.Event
+	stop := errors.New("stop")
+	err := c.Events(ctx, "", func(ev client.Event) error {
+		got = append(got, ev)
+		if len(got) == 1 {
+			feed.Publish(clipsync.Version{Origin: "a", Clock: 2, Hash: clipsync.Hash("second")}, "second")
+			return nil
+		}
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("Events = %v", err)
+	}
+	if got[0].Content != "first" || got[1].Content != "second" || got[1].Clock != 2 || got[1].ID != clipsync.Hash("second") {
+		t.Errorf("events = %+v", got)
+	}
+
+	// Resuming from the last ID skips the copy already seen.
+	ctx2, cancel2 := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel2()
+	err = c.Events(ctx2, got[1].ID, func(ev client.Event) error {
+		t.Errorf("resumed stream repeated %+v", ev)
+		return nil
+	})
+	if err == nil {
+		t.Error("Events returned nil")
+	}
+}
+
+// TestLegacyServer talks to a server from before the handshake: no /v1 paths.
+func TestLegacyServer(t *testing.T) {
+	srv := newServer(t, nil)
+	var paths []string
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, protocol.Prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer legacy.Close()
+	ctx := context.Background()
+	c := client.New(legacy.URL)
+
+	if err := c.Push(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := c.Pull(ctx); err != nil || text != "old" {
+		t.Errorf("Pull = %q, %v", text, err)
+	}
+	if want := []string{"/v1/hello", "/clipboard", "/clipboard"}; strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("requested %q, want %q", paths, want)
+	}
+	if _, _, _, err := c.Wait(ctx, `"x"`, time.Second); !errors.Is(err, client.ErrNotSupported) {
+		t.Errorf("Wait on a legacy server: err = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestIncompatibleServer(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		w.Write([]byte(`{"protocol": 3, "min_protocol": 2, "capabilities": []}`))
+	}))
+	defer srv.Close()
+
+	err := client.New(srv.URL).Push(context.Background(), "x")
+	var ie *protocol.IncompatibleError
+	if !errors.As(err, &ie) || ie.Min != 2 || !strings.Contains(err.Error(), "upgrade xconnect here") {
+		t.Errorf("Push = %v, want an IncompatibleError", err)
+	}
+	if calls != 1 {
+		t.Errorf("made %d requests, want only the handshake", calls)
+	}
+	if client.IsUnreachable(err) {
+		t.Error("IsUnreachable is true for a version mismatch")
+	}
+}
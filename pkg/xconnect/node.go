@@ -0,0 +1,757 @@
+// Package xconnect runs an XConnect node: the HTTP API, clipboard sync, peer
+// discovery and the outbox, as started by the xconnect command.
+//
+//	opts := xconnect.DefaultOptions()
+//	opts.Sync = true
+//	node, err := xconnect.New(opts)
+//	if err != nil { ... }
+//	if err := node.Start(ctx); err != nil { ... }
+//	defer node.Stop(context.Background())
+package xconnect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/xconnect/xconnect-go/internal/approval"
+	xclipboard "github.com/xconnect/xconnect-go/internal/clipboard"
+	"github.com/xconnect/xconnect-go/internal/discovery"
+	"github.com/xconnect/xconnect-go/internal/filter"
+	"github.com/xconnect/xconnect-go/internal/outbox"
+	"github.com/xconnect/xconnect-go/internal/protocol"
+	"github.com/xconnect/xconnect-go/internal/server"
+	clipsync "github.com/xconnect/xconnect-go/internal/sync"
+	"tailscale.com/client/tailscale"
+)
+
+// Version is the release reported on GET /status, set at build time with
+// -ldflags "-X github.com/xconnect/xconnect-go/pkg/xconnect.Version=v1.2.3". When
+// empty, the module version from the build info is used.
+var Version string
+
+// version returns Version, the module version, or "dev".
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "dev"
+}
+
+// Options configures a Node. Start from DefaultOptions: the zero value disables the
+// content filters.
+type Options struct {
+	// Addr to listen on (default ":8315").
+	Addr string
+	// Tsnet runs an embedded Tailscale node instead of using the system's.
+	Tsnet bool
+	// Hostname is the tailnet hostname with Tsnet (default "xconnect"), the mDNS
+	// instance name, and our name among the peers until discovery reports it.
+	Hostname string
+	// AuthKey for Tsnet (default $TS_AUTHKEY).
+	AuthKey string
+	// Listener, if set, is served instead of listening on Addr.
+	Listener net.Listener
+
+	// Sync enables clipboard auto-sync with the discovered peers.
+	Sync          bool
+	SyncInterval  time.Duration // poll interval without change notifications (default 1s)
+	SyncPoll      bool          // always poll instead of using change notifications
+	SyncMode      string        // both (default), send-only or receive-only
+	SyncPrimary   bool          // also sync the PRIMARY selection (Linux/BSD)
+	SyncTopology  string        // mesh (default), hub or leader
+	SyncCenter    string        // hub or leader hostname
+	SyncTransport string        // push (default), subscribe or both
+	SyncPeers     string        // selector limiting the discovered peers, e.g. "tag:xconnect"
+
+	// Discovery providers, comma-separated (default "tailscale"); see the -discovery flag.
+	Discovery         string
+	DiscoveryInterval time.Duration // default 30s
+	Peers             []string      // static peer hostnames or IPs; overrides discovery
+	APIToken          string
+	APIBase           string // default discovery.DefaultAPIBase
+	Tailnet           string // default "-"
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthTokenURL     string
+	HeadscaleURL      string
+	HeadscaleKey      string
+	PeersFile         string
+	// MDNS advertises the node on the LAN (implied by the mdns discovery provider).
+	MDNS bool
+
+	// Content filters (see the filter-* flags).
+	FilterSecrets   bool
+	FilterConcealed bool
+	FilterMaxSize   int
+	FilterDeny      []string
+
+	// Approval of incoming clipboard: auto (default), ask or reject, per-peer rules
+	// ("laptop=auto,phone-*=ask") and how long held updates are kept (default 2m).
+	Accept        string
+	AcceptPeers   string
+	AcceptTimeout time.Duration
+
+	// OutboxDir keeps updates for offline peers (empty = no outbox).
+	OutboxDir    string
+	OutboxExpiry time.Duration
+
+	// FilesDir receives uploaded files (default "xconnect-files"); HistorySize and
+	// HistoryMaxAge limit the clipboard history (default 50 entries, no age limit).
+	FilesDir      string
+	HistorySize   int
+	HistoryMaxAge time.Duration
+
+	// RemoteStatus serves GET /status and GET /metrics to peers too; by default
+	// only local callers get them.
+	RemoteStatus bool
+
+	// OnClipboardReceived is called after clipboard content from a peer was written
+	// (including accepted pending updates and messages).
+	OnClipboardReceived func(from, content string)
+	// OnPending is called when an incoming update is held for approval.
+	OnPending func(Pending)
+	// OnPeersChanged is called with the sync peers after every discovery refresh.
+	OnPeersChanged func([]Peer)
+}
+
+// DefaultOptions returns the defaults of the xconnect command.
+func DefaultOptions() Options {
+	return Options{
+		Addr:              ":8315",
+		SyncInterval:      time.Second,
+		SyncMode:          clipsync.ModeBoth,
+		SyncTopology:      clipsync.TopologyMesh,
+		SyncTransport:     clipsync.TransportPush,
+		Discovery:         "tailscale",
+		DiscoveryInterval: 30 * time.Second,
+		APIBase:           discovery.DefaultAPIBase,
+		Tailnet:           "-",
+		FilterSecrets:     true,
+		FilterConcealed:   true,
+		FilterMaxSize:     1 << 20,
+		Accept:            approval.Auto,
+		AcceptTimeout:     2 * time.Minute,
+		OutboxDir:         outbox.DefaultDir(),
+		OutboxExpiry:      24 * time.Hour,
+	}
+}
+
+// Pending is an incoming clipboard update held for approval.
+type Pending = approval.Pending
+
+// Peer is a discovered peer.
+type Peer struct {
+	Name    string
+	BaseURL string
+	Offline bool
+}
+
+// Node is a running XConnect node.
+type Node struct {
+	opts Options // as given to New; Reload applies changes to the objects below
+
+	filter        *filter.Filter
+	primaryFilter *filter.Filter
+	state         *clipsync.State
+	primaryState  *clipsync.State
+	control       *clipsync.Control
+	pending       *approval.Queue
+	topology      *clipsync.Topology
+	feed          *clipsync.Feed
+	relay         chan clipsync.Update
+	primaryRelay  chan clipsync.Update
+	sel           atomic.Pointer[discovery.Selector]
+	policy        atomic.Pointer[approval.Policy]
+	storage       *server.Storage
+	recv, precv   *receiver // CLIPBOARD and PRIMARY (may be nil) receivers
+	stats         *clipsync.Stats
+
+	ln      net.Listener
+	srv     *http.Server
+	handler *server.Handler
+	disc    *discovery.Manager
+	self    string // our name among the peers
+	started time.Time
+	mode    string // server.ListenerTsnet, ListenerSystem or ListenerCustom
+	watch   string // clipboard change detection backend (with Sync)
+
+	wg         sync.WaitGroup // background goroutines
+	finishOnce sync.Once
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+	stopped  bool
+	syncMode string // the configured -sync-mode, last applied by New or Reload
+}
+
+// New validates opts and returns a Node ready to Start.
+func New(opts Options) (*Node, error) {
+	opts = withDefaults(opts)
+	n := &Node{opts: opts, syncMode: opts.SyncMode, feed: clipsync.NewFeed(), stats: &clipsync.Stats{},
+		relay: make(chan clipsync.Update, 16), primaryRelay: make(chan clipsync.Update, 16)}
+
+	filterOpts, err := filterOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	n.filter = filter.New(filterOpts)
+	n.state = clipsync.NewState("")
+	if n.control, err = clipsync.NewControl(opts.Sync, opts.SyncMode); err != nil {
+		return nil, fmt.Errorf("sync-mode: %w", err)
+	}
+	policy, err := approval.ParsePolicy(opts.Accept, opts.AcceptPeers)
+	if err != nil {
+		return nil, fmt.Errorf("accept: %w", err)
+	}
+	n.policy.Store(policy)
+	n.pending = &approval.Queue{TTL: opts.AcceptTimeout, OnAdd: opts.OnPending}
+	if n.topology, err = clipsync.ParseTopology(opts.SyncTopology, opts.SyncCenter); err != nil {
+		return nil, fmt.Errorf("sync-topology: %w", err)
+	}
+	switch opts.SyncTransport {
+	case clipsync.TransportPush, clipsync.TransportSubscribe, clipsync.TransportBoth:
+	default:
+		return nil, fmt.Errorf("sync-transport: unknown transport %q (want push, subscribe or both)", opts.SyncTransport)
+	}
+	sel, err := discovery.ParseSelector(opts.SyncPeers)
+	if err != nil {
+		return nil, fmt.Errorf("sync-peers: %w", err)
+	}
+	n.sel.Store(sel)
+	n.storage = server.NewStorage(opts.FilesDir, opts.HistorySize, opts.HistoryMaxAge)
+
+	n.self = opts.Hostname
+	n.recv = &receiver{state: n.state, filter: n.filter, control: n.control, policy: &n.policy, pending: n.pending,
+		topology: n.topology, self: func() string { return n.self }, relay: n.relay, hostByAddr: n.hostByAddr,
+		onReceived: func(from, content string) {
+			n.stats.Received(from)
+			if opts.OnClipboardReceived != nil {
+				opts.OnClipboardReceived(from, content)
+			}
+		}}
+	handlerOpts := &server.HandlerOpts{
+		ReceiveClipboard: n.recv.receive,
+		Sync:             n.control,
+		Pending:          n.pending,
+		Events:           n.feed,
+		Status:           n.status,
+		Storage:          n.storage,
+		ClipboardReads:   opts.Sync,
+		RemoteStatus:     opts.RemoteStatus,
+	}
+	// PRIMARY is a separate channel with its own state, so a selection never echoes
+	// back and never competes with CLIPBOARD versions.
+	if opts.Sync && opts.SyncPrimary {
+		if xclipboard.PrimaryAvailable() {
+			n.primaryState = clipsync.NewState(n.state.NodeID)
+			filterOpts.Concealed = nil // see filterOptions
+			n.primaryFilter = filter.New(filterOpts)
+			r := *n.recv
+			r.state, r.filter, r.primary = n.primaryState, n.primaryFilter, true
+			r.relay, r.onReceived = n.primaryRelay, func(from, _ string) { n.stats.Received(from) }
+			n.precv = &r
+			handlerOpts.ReceivePrimary = n.precv.receive
+		} else {
+			slog.Warn("sync-primary: disabled", "err", xclipboard.ErrNoPrimary)
+		}
+	}
+	n.handler = server.NewHandler(handlerOpts)
+	return n, nil
+}
+
+// withDefaults fills in the options whose zero value is not usable.
+func withDefaults(opts Options) Options {
+	def := DefaultOptions()
+	if opts.Addr == "" {
+		opts.Addr = def.Addr
+	}
+	if opts.SyncMode == "" {
+		opts.SyncMode = def.SyncMode
+	}
+	if opts.SyncTransport == "" {
+		opts.SyncTransport = def.SyncTransport
+	}
+	if opts.Discovery == "" {
+		opts.Discovery = def.Discovery
+	}
+	if opts.APIBase == "" {
+		opts.APIBase = def.APIBase
+	}
+	if opts.Tailnet == "" {
+		opts.Tailnet = def.Tailnet
+	}
+	return opts
+}
+
+// filterOptions builds the CLIPBOARD filter rules; PRIMARY uses them without
+// Concealed, since password hints are on CLIPBOARD only.
+func filterOptions(opts Options) (filter.Options, error) {
+	deny, err := filter.ParseDeny(opts.FilterDeny)
+	if err != nil {
+		return filter.Options{}, fmt.Errorf("filter-deny: %w", err)
+	}
+	f := filter.Options{Deny: deny, Secrets: opts.FilterSecrets, MaxSize: opts.FilterMaxSize}
+	if opts.FilterConcealed {
+		f.Concealed = xclipboard.Concealed
+	}
+	return f, nil
+}
+
+// Handler returns the node's HTTP API handler, e.g. to mount it on another server.
+func (n *Node) Handler() http.Handler { return n.handler }
+
+// Addr returns the address the node listens on (after Start).
+func (n *Node) Addr() net.Addr {
+	if n.ln == nil {
+		return nil
+	}
+	return n.ln.Addr()
+}
+
+// Start listens, starts discovery and sync, and serves the API in the background
+// until Stop. ctx bounds the startup (e.g. joining the tailnet and the first
+// discovery refresh).
+func (n *Node) Start(ctx context.Context) error {
+	n.mu.Lock()
+	if n.done != nil {
+		n.mu.Unlock()
+		return errors.New("xconnect: node already started")
+	}
+	n.done = make(chan struct{})
+	n.mu.Unlock()
+
+	opts := &n.opts
+	var err error
+	switch {
+	case opts.Listener != nil:
+		n.ln, n.mode = opts.Listener, server.ListenerCustom
+	case opts.Tsnet:
+		n.mode = server.ListenerTsnet
+		host := opts.Hostname
+		if host == "" {
+			host = "xconnect"
+			n.self = host
+		}
+		n.ln, err = server.ListenTsnet(host, opts.AuthKey, opts.Addr)
+	default:
+		n.mode = server.ListenerSystem
+		n.ln, err = server.ListenSystem(opts.Addr)
+	}
+	if err != nil {
+		close(n.done)
+		return err
+	}
+	// Receivers identify peers with WhoIs on the tsnet node or system tailscaled.
+	lc := localClient(n.ln)
+	if lc == nil && !opts.Tsnet {
+		lc = &tailscale.LocalClient{}
+	}
+	n.recv.lc = lc
+	if n.precv != nil {
+		n.precv.lc = lc
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	n.mu.Lock()
+	n.cancel = cancel
+	n.mu.Unlock()
+
+	port := strconv.Itoa(listenPort(n.ln, opts.Addr))
+	var mdnsOpts discovery.MDNSOptions
+	if opts.MDNS || discovery.HasProvider(opts.Discovery, discovery.SourceMDNS) {
+		mdnsOpts.Instance = opts.Hostname
+		mdnsOpts.Port, _ = strconv.Atoi(port)
+		n.background(func() {
+			if err := discovery.AdvertiseMDNS(runCtx, mdnsOpts); err != nil {
+				slog.Error("mdns: advertise", "err", err)
+			}
+		})
+		slog.Info("advertising on the LAN via mDNS", "service", discovery.MDNSService)
+	}
+
+	if opts.Sync {
+		if err := n.startSync(ctx, runCtx, port, mdnsOpts); err != nil {
+			cancel()
+			n.ln.Close()
+			close(n.done)
+			return err
+		}
+	}
+
+	n.started = time.Now()
+	n.srv = server.NewServer(n.handler)
+	slog.Info("xconnect listening", "addr", n.ln.Addr().String(), "listener", n.mode, "version", version())
+	go func() {
+		err := n.srv.Serve(server.Socket(n.ln))
+		if errors.Is(err, http.ErrServerClosed) {
+			return // Stop finishes the shutdown
+		}
+		n.halt(context.Background())
+		n.finish(err)
+	}()
+	return nil
+}
+
+// background runs f in a goroutine that Stop waits for.
+func (n *Node) background(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+func (n *Node) startSync(ctx, runCtx context.Context, port string, mdnsOpts discovery.MDNSOptions) error {
+	opts := &n.opts
+	lc := localClient(n.ln)
+	cfg := discovery.Config{
+		Providers:         opts.Discovery,
+		Static:            opts.Peers,
+		APIToken:          opts.APIToken,
+		APIBase:           opts.APIBase,
+		Tailnet:           opts.Tailnet,
+		OAuthClientID:     opts.OAuthClientID,
+		OAuthClientSecret: opts.OAuthClientSecret,
+		OAuthTokenURL:     opts.OAuthTokenURL,
+		HeadscaleURL:      opts.HeadscaleURL,
+		HeadscaleKey:      opts.HeadscaleKey,
+		HostsFile:         opts.PeersFile,
+		MDNS:              mdnsOpts,
+		LocalClient:       lc,
+	}
+	provider, err := discovery.NewProvider(cfg)
+	if err != nil {
+		return err
+	}
+	var ob *outbox.Outbox
+	if opts.OutboxDir != "" {
+		if ob, err = outbox.Open(opts.OutboxDir, opts.OutboxExpiry); err != nil {
+			return fmt.Errorf("outbox: %w", err)
+		}
+	}
+	discOpts := discovery.ManagerOptions{
+		Provider: provider,
+		Interval: opts.DiscoveryInterval,
+		OnUpdate: func(*discovery.Snapshot) {
+			if ob != nil {
+				ob.Kick()
+			}
+			if opts.OnPeersChanged != nil {
+				opts.OnPeersChanged(n.Peers())
+			}
+		},
+	}
+	if len(cfg.Static) == 0 && discovery.HasProvider(cfg.Providers, discovery.SourceTailscale) {
+		discOpts.Watch = discovery.WatchNetMap(lc)
+	}
+	n.disc = discovery.NewManager(discOpts)
+	if err := n.disc.RefreshNow(ctx); err != nil {
+		slog.Warn("discovery: initial refresh failed", "err", err)
+	}
+	n.background(func() { n.disc.Run(runCtx) })
+	if s := n.disc.SelfHost(); s != "" {
+		n.self = s
+	}
+	getPeers := func() []clipsync.Peer {
+		var peers []clipsync.Peer
+		for _, p := range n.peers(port) {
+			peers = append(peers, clipsync.Peer{Name: p.Name, BaseURL: p.BaseURL, Offline: p.Offline})
+		}
+		return peers
+	}
+	getClipboard := func() string {
+		s, _ := clipboard.ReadAll()
+		n.handler.ClipboardRead(s) // wakes long-polls without a watcher of their own
+		return s
+	}
+	getFromHost := func() string { return n.self }
+	protoCache := &protocol.Cache{Client: &http.Client{Timeout: 10 * time.Second}}
+	watcher := xclipboard.Watch(runCtx, xclipboard.WatchOptions{PollInterval: opts.SyncInterval, PollOnly: opts.SyncPoll})
+	n.watch = watcher.Backend()
+	slog.Info("clipboard change detection", "backend", n.watch)
+	syncOpts := clipsync.Options{
+		Interval:     opts.SyncInterval,
+		Changes:      watcher.C,
+		GetClipboard: getClipboard,
+		State:        n.state,
+		GetPeers:     getPeers,
+		GetFromHost:  getFromHost,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		Outbox:       ob,
+		Filter:       n.filter,
+		Control:      n.control,
+		Topology:     n.topology,
+		Relay:        n.relay,
+		Transport:    opts.SyncTransport,
+		Feed:         n.feed,
+		Protocol:     protoCache,
+		Stats:        n.stats,
+	}
+	n.background(func() { clipsync.ClipboardSync(runCtx, syncOpts) })
+	if clipsync.Subscribes(opts.SyncTransport) {
+		subOpts := clipsync.SubscribeOptions{
+			GetPeers:    getPeers,
+			Interval:    opts.DiscoveryInterval,
+			GetFromHost: getFromHost,
+			Apply:       n.recv.applyEvent(runCtx, n.handler.ApplyRemote),
+			Stats:       n.stats,
+		}
+		n.background(func() { clipsync.Subscribe(runCtx, subOpts) })
+		slog.Info("subscribing to peers' clipboard events", "transport", opts.SyncTransport)
+	}
+	if n.primaryState != nil {
+		pw := xclipboard.Watch(runCtx, xclipboard.WatchOptions{PollInterval: opts.SyncInterval, PollOnly: opts.SyncPoll, Selection: xclipboard.SelectionPrimary})
+		primaryOpts := clipsync.Options{
+			Interval: opts.SyncInterval,
+			Changes:  pw.C,
+			Debounce: 300 * time.Millisecond,
+			Path:     "/clipboard/primary",
+			GetClipboard: func() string {
+				s, _ := xclipboard.ReadPrimary()
+				return s
+			},
+			State:       n.primaryState,
+			GetPeers:    getPeers,
+			GetFromHost: getFromHost,
+			HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+			Filter:      n.primaryFilter,
+			Control:     n.control,
+			Topology:    n.topology,
+			Relay:       n.primaryRelay,
+			Protocol:    protoCache,
+			Capability:  protocol.CapPrimary,
+			Stats:       n.stats,
+		}
+		n.background(func() { clipsync.ClipboardSync(runCtx, primaryOpts) })
+		slog.Info("PRIMARY selection sync enabled", "backend", pw.Backend())
+	}
+	if ob != nil {
+		deliverOpts := outbox.DeliverOptions{
+			Targets:     func() []outbox.Target { return onlineTargets(n.disc.Peers(), port) },
+			Interval:    opts.DiscoveryInterval,
+			GetFromHost: getFromHost,
+			Protocol:    protoCache,
+			CanSend:     n.control.CanSend,
+		}
+		n.background(func() { ob.Run(runCtx, deliverOpts) })
+		slog.Info("outbox enabled", "dir", ob.Dir, "expiry", opts.OutboxExpiry)
+	}
+	if n.topology.Mode != clipsync.TopologyMesh {
+		role := "member"
+		switch {
+		case n.topology.IsCenter(n.self):
+			role = n.topology.Mode
+		case n.topology.Mode == clipsync.TopologyLeader:
+			role = "follower"
+		}
+		slog.Info("sync topology", "topology", n.topology.String(), "self", n.self, "role", role)
+	}
+	if sel := n.sel.Load(); !sel.Empty() {
+		slog.Info("clipboard auto-sync limited to matching peers", "selector", sel.String())
+	}
+	slog.Info("clipboard auto-sync enabled", "filters", n.filter.String())
+	return nil
+}
+
+// Peers returns the discovered sync peers (nil without Sync).
+func (n *Node) Peers() []Peer {
+	if n.disc == nil {
+		return nil
+	}
+	return n.peers(strconv.Itoa(listenPort(n.ln, n.opts.Addr)))
+}
+
+func (n *Node) peers(port string) []Peer {
+	var peers []Peer
+	for _, d := range n.sel.Load().Filter(n.disc.Peers()) {
+		if d.HostName == n.self {
+			continue
+		}
+		if u := discovery.BaseURL(d, port); u != "" {
+			peers = append(peers, Peer{Name: d.HostName, BaseURL: u, Offline: d.Offline})
+		}
+	}
+	return peers
+}
+
+// hostByAddr returns the hostname discovery reports for the peer at ip, or "".
+func (n *Node) hostByAddr(ip string) string {
+	if n.disc == nil {
+		return ""
+	}
+	for _, d := range n.disc.Peers() {
+		if d.IP == ip {
+			return d.HostName
+		}
+		for _, a := range d.Addrs {
+			if strings.Split(a, "/")[0] == ip {
+				return d.HostName
+			}
+		}
+	}
+	return ""
+}
+
+// status adds what the node knows to the GET /status report.
+func (n *Node) status(st *server.Status) {
+	st.Version = version()
+	st.Hostname = n.self
+	st.Started = n.started
+	st.Listener = n.mode
+	if n.ln != nil {
+		st.Addr = n.ln.Addr().String()
+	}
+	st.Clipboard.Watch = n.watch
+	if st.Sync != nil {
+		snap := n.stats.Snapshot()
+		st.Sync.Topology = n.topology.String()
+		st.Sync.Transport = n.opts.SyncTransport
+		st.Sync.Sent, st.Sync.Received, st.Sync.Failed = snap.Sent, snap.Received, snap.Failed
+		st.Sync.LastSent, st.Sync.LastReceived = snap.LastSent, snap.LastReceived
+	}
+	if n.disc == nil {
+		return
+	}
+	d := &server.DiscoveryStatus{Providers: n.opts.Discovery, UpdatedAt: n.disc.Snapshot().UpdatedAt}
+	if len(n.opts.Peers) > 0 {
+		d.Providers = "static"
+	}
+	if at, err := n.disc.LastError(); err != nil {
+		d.LastError, d.LastErrorAt = err.Error(), at
+	}
+	st.Discovery = d
+	for _, p := range n.Peers() {
+		ps := server.PeerStatus{PeerStats: n.stats.Peer(p.Name), URL: p.BaseURL, Offline: p.Offline}
+		ps.Name = p.Name
+		st.Peers = append(st.Peers, ps)
+	}
+}
+
+// Stop shuts the node down gracefully: it stops accepting requests, ends long-polls
+// and event streams, and waits for the other in-flight requests such as uploads. Then
+// it stops sync (moving undelivered updates to the outbox), discovery and outbox
+// delivery, and closes the listener, leaving the tailnet with Tsnet. Last it saves the
+// clipboard history and the received files index in the files directory, where the
+// next start picks them up. When ctx is done first, the remaining requests are cut
+// off and ctx's error is returned.
+func (n *Node) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	if n.srv == nil || n.stopped {
+		n.mu.Unlock()
+		return nil
+	}
+	n.stopped = true
+	n.mu.Unlock()
+	err := n.srv.Shutdown(ctx)
+	if herr := n.halt(ctx); err == nil {
+		err = herr
+	}
+	if serr := n.handler.Save(); serr != nil {
+		slog.Error("server: saving history and files index", "err", serr)
+	}
+	n.finish(nil)
+	return err
+}
+
+// halt stops the background work, waiting for it until ctx is done, and closes the
+// listener.
+func (n *Node) halt(ctx context.Context) error {
+	n.mu.Lock()
+	cancel := n.cancel
+	n.mu.Unlock()
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(stopped)
+	}()
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	n.ln.Close()
+	return err
+}
+
+// finish records the serve error and releases Wait, once.
+func (n *Node) finish(err error) {
+	n.finishOnce.Do(func() {
+		n.mu.Lock()
+		n.err = err
+		n.mu.Unlock()
+		close(n.done)
+	})
+}
+
+// Wait blocks until the node has stopped (see Stop) or serving failed, and returns the
+// serve error, if any.
+func (n *Node) Wait() error {
+	n.mu.Lock()
+	done := n.done
+	n.mu.Unlock()
+	if done == nil {
+		return errors.New("xconnect: node not started")
+	}
+	<-done
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// onlineTargets returns the peers that are not reported offline, for outbox delivery.
+func onlineTargets(peers []discovery.Device, port string) []outbox.Target {
+	var targets []outbox.Target
+	for _, d := range peers {
+		u := discovery.BaseURL(d, port)
+		if d.Offline || u == "" {
+			continue
+		}
+		names := append([]string{d.HostName, d.IP}, d.Addrs...)
+		targets = append(targets, outbox.Target{Names: names, BaseURL: u})
+	}
+	return targets
+}
+
+// listenPort returns the port ln listens on, falling back to the one in addr.
+func listenPort(ln net.Listener, addr string) int {
+	if ln != nil {
+		if _, p, err := net.SplitHostPort(ln.Addr().String()); err == nil {
+			if n, err := strconv.Atoi(p); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	_, p, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(strings.TrimPrefix(p, ":"))
+	return n
+}
+
+// localClient returns the tsnet LocalClient when ln is backed by tsnet, else nil (system tailscaled).
+func localClient(ln net.Listener) *tailscale.LocalClient {
+	if t, ok := ln.(interface {
+		LocalClient() (*tailscale.LocalClient, error)
+	}); ok {
+		if lc, err := t.LocalClient(); err == nil {
+			return lc
+		}
+	}
+	return nil
+}
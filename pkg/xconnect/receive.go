@@ -0,0 +1,179 @@
+package xconnect
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xconnect/xconnect-go/internal/approval"
+	xclipboard "github.com/xconnect/xconnect-go/internal/clipboard"
+	"github.com/xconnect/xconnect-go/internal/filter"
+	"github.com/xconnect/xconnect-go/internal/server"
+	clipsync "github.com/xconnect/xconnect-go/internal/sync"
+	"tailscale.com/client/tailscale"
+)
+
+// receiver decides what happens to clipboard content POSTed by peers (server.HandlerOpts.ReceiveClipboard).
+type receiver struct {
+	state   *clipsync.State
+	filter  *filter.Filter
+	control *clipsync.Control
+	policy  *atomic.Pointer[approval.Policy] // replaced on Reload
+	pending *approval.Queue
+	lc      *tailscale.LocalClient // identifies peers with WhoIs; nil = through hostByAddr
+	primary bool                   // PRIMARY selection: no approval queue, "ask" drops
+	// hostByAddr names a peer by its address from discovery when WhoIs cannot (mDNS,
+	// hosts file); it returns "" for unknown addresses. May be nil.
+	hostByAddr func(ip string) string
+
+	topology *clipsync.Topology
+	self     func() string          // our hostname, for the topology
+	relay    chan<- clipsync.Update // applied updates to forward when we are the hub; may be nil
+
+	onReceived func(from, content string) // after content from a peer was written; may be nil
+}
+
+// receive is server.HandlerOpts.ReceiveClipboard: it applies content POSTed by a peer.
+func (rc *receiver) receive(r *http.Request, content string, write func(string) error) (bool, string, error) {
+	v, versioned := clipsync.VersionFromHeaders(r.Header)
+	return rc.apply(r.Context(), r.RemoteAddr, v, versioned, content, write)
+}
+
+// apply checks pause/mode, filters and the peer's approval policy, then applies the
+// content to state: versioned updates only when newer, unversioned ones (CLI push,
+// messages, older releases) always. Sync updates that are filtered or arrive while
+// receiving is paused or off are acknowledged as ignored; unversioned content is
+// refused instead, so the sender sees an error. remoteAddr identifies the sender.
+func (rc *receiver) apply(ctx context.Context, remoteAddr string, v clipsync.Version, versioned bool, content string, write func(string) error) (bool, string, error) {
+	v.Hash = clipsync.Hash(content) // never trust the sender's hash
+	if ok, reason := rc.control.CanReceive(); !ok {
+		if !versioned {
+			return false, "", fmt.Errorf("%w: clipboard sync is %s on the receiving device", server.ErrRefused, reason)
+		}
+		return false, reason, nil
+	}
+	name, addr := rc.peer(ctx, remoteAddr)
+	if rc.onReceived != nil {
+		w := write
+		write = func(s string) error {
+			if err := w(s); err != nil {
+				return err
+			}
+			rc.onReceived(name, s)
+			return nil
+		}
+	}
+	// The topology routes sync traffic; a manual push or message is always taken.
+	if ok, reason := rc.topology.Accepts(rc.self(), name); versioned && !ok {
+		return false, reason, nil
+	}
+	if !rc.filter.Allow(filter.Incoming, name, content) {
+		if !versioned {
+			// A manual push or message: tell the user instead of dropping it silently.
+			return false, "", fmt.Errorf("%w: content blocked by the receiving device's filter", server.ErrRefused)
+		}
+		return false, "filtered", nil
+	}
+	switch rc.policy.Load().For(name, addr) {
+	case approval.Reject:
+		slog.Info("approval: rejected clipboard", "from", name)
+		return false, "rejected", nil
+	case approval.Ask:
+		if rc.primary {
+			// Selections change too often to ask about each one.
+			return false, "approval-required", nil
+		}
+		cur := rc.state.Current()
+		if cur.Hash == clipsync.Hash(content) {
+			return false, "duplicate", nil
+		}
+		if versioned && v.Less(cur) {
+			return false, "stale", nil
+		}
+		// An accepted item wins regardless of what arrived meanwhile.
+		p := rc.pending.Add(name, content, func() error {
+			_, err := rc.state.ApplyUnversioned(name, content, write)
+			return err
+		})
+		slog.Info("approval: holding clipboard", "from", name, "bytes", len(content), "id", p.ID)
+		return false, "pending", nil
+	}
+	if !versioned {
+		var err error
+		if v, err = rc.state.ApplyUnversioned(name, content, write); err != nil {
+			return false, "", err
+		}
+	} else if applied, reason, err := rc.state.Apply(v, content, write); !applied {
+		if err == nil {
+			slog.Debug("sync: ignored clipboard update", "reason", reason, "from", name, "clock", v.Clock)
+		}
+		return false, reason, err
+	}
+	rc.forward(clipsync.Update{Version: v, Content: content, From: name})
+	return true, "", nil
+}
+
+// forward hands an applied update to the sync loop for relaying, if we are the hub.
+func (rc *receiver) forward(u clipsync.Update) {
+	if rc.relay == nil || !rc.topology.Relays(rc.self()) {
+		return
+	}
+	select {
+	case rc.relay <- u:
+	default:
+		slog.Warn("sync: relay queue full, update not relayed", "from", u.From)
+	}
+}
+
+// peer returns the sender's name and address. The name comes from WhoIs, else from
+// discovery; it is the address when neither knows the sender.
+func (rc *receiver) peer(ctx context.Context, remoteAddr string) (name, addr string) {
+	addr = remoteAddr
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		addr = strings.Trim(addr[:i], "[]")
+	}
+	if rc.lc != nil {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		if who, err := rc.lc.WhoIs(ctx, remoteAddr); err == nil && who.Node != nil {
+			if n := who.Node.ComputedName; n != "" {
+				return n, addr
+			}
+			return strings.Split(who.Node.Name, ".")[0], addr
+		}
+	}
+	if rc.hostByAddr != nil {
+		if n := rc.hostByAddr(addr); n != "" {
+			return n, addr
+		}
+	}
+	return addr, addr
+}
+
+// applyEvent returns a clipsync.SubscribeOptions.Apply that applies events from a
+// peer's stream like updates POSTed to /clipboard: with the same checks and approval,
+// recording applied content with record (server.Handler.ApplyRemote) for history.
+func (rc *receiver) applyEvent(ctx context.Context, record func(from, content string)) func(clipsync.Peer, clipsync.Event) {
+	return func(p clipsync.Peer, ev clipsync.Event) {
+		clipsync.ClipboardBytes.Add(float64(len(ev.Content)), "received")
+		addr := p.BaseURL // identifies the peer for WhoIs and -accept-peers
+		if u, err := url.Parse(p.BaseURL); err == nil {
+			addr = u.Host
+		}
+		v := ev.Version()
+		applied, reason, err := rc.apply(ctx, addr, v, v.Origin != "", ev.Content, xclipboard.WriteAll)
+		switch {
+		case err != nil:
+			slog.Warn("sync: event not applied", "peer", p.Name, "err", err)
+		case applied:
+			record(p.Name, ev.Content)
+		default:
+			slog.Debug("sync: event ignored", "peer", p.Name, "reason", reason)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package xconnect
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/xconnect/xconnect-go/internal/approval"
+	"github.com/xconnect/xconnect-go/internal/discovery"
+)
+
+// reloadable are the Options that Reload applies to a running node.
+var reloadable = map[string]bool{
+	"SyncMode":        true,
+	"SyncPeers":       true,
+	"FilterSecrets":   true,
+	"FilterConcealed": true,
+	"FilterMaxSize":   true,
+	"FilterDeny":      true,
+	"Accept":          true,
+	"AcceptPeers":     true,
+	"AcceptTimeout":   true,
+	"FilesDir":        true,
+	"HistorySize":     true,
+	"HistoryMaxAge":   true,
+	"RemoteStatus":    true,
+}
+
+// Reload applies the options that can change while the node runs, without
+// dropping the listener or sync: the content filters, the sync mode and peer
+// selector, the approval policy and timeout, the files directory, the history
+// limits and remote access to /status. Nothing is applied if any of them is invalid.
+// It returns the names of the other options that differ from those the node was
+// started with; they take effect on restart. The Options of the node itself are
+// never changed: each reloadable value lives in the object that uses it.
+func (n *Node) Reload(opts Options) (restart []string, err error) {
+	opts = withDefaults(opts)
+	filterOpts, err := filterOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := approval.ParsePolicy(opts.Accept, opts.AcceptPeers)
+	if err != nil {
+		return nil, fmt.Errorf("accept: %w", err)
+	}
+	sel, err := discovery.ParseSelector(opts.SyncPeers)
+	if err != nil {
+		return nil, fmt.Errorf("sync-peers: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// Only a changed mode is applied, so one set with POST /sync/mode survives
+	// reloads that do not touch it.
+	if opts.SyncMode != n.syncMode {
+		if err := n.control.SetMode(opts.SyncMode); err != nil {
+			return nil, fmt.Errorf("sync-mode: %w", err)
+		}
+		n.syncMode = opts.SyncMode
+	}
+	n.filter.SetOptions(filterOpts)
+	if n.primaryFilter != nil {
+		filterOpts.Concealed = nil
+		n.primaryFilter.SetOptions(filterOpts)
+	}
+	n.policy.Store(policy)
+	n.pending.SetTTL(opts.AcceptTimeout)
+	n.sel.Store(sel)
+	n.storage.Set(opts.FilesDir, opts.HistorySize, opts.HistoryMaxAge)
+	n.handler.SetRemoteStatus(opts.RemoteStatus)
+
+	cur, next := reflect.ValueOf(n.opts), reflect.ValueOf(opts)
+	for i := 0; i < cur.NumField(); i++ {
+		f := cur.Type().Field(i)
+		switch {
+		case reloadable[f.Name], f.Type.Kind() == reflect.Func, f.Name == "Listener":
+		case !reflect.DeepEqual(cur.Field(i).Interface(), next.Field(i).Interface()):
+			restart = append(restart, f.Name)
+		}
+	}
+	return restart, nil
+}